@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+)
+
+func drillCommand(args []string) {
+	flags := flag.NewFlagSet("drill", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s drill [flags] <backup|latest>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBackupFlags(flags)
+	common.addBlobFlags(flags)
+	common.addEncryptionFlags(flags)
+
+	output := flags.String("output", "text", "format of the drill report, text or json")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	if flags.NArg() != 1 || (*output != "text" && *output != "json") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	common.validateBlobFlags(setupLog)
+
+	blobClient := common.createBlobClient(setupLog)
+	defer blobClient.Close()
+
+	cipher := common.createCipher(setupLog)
+
+	drillCTX, drillCancel := context.WithTimeout(context.Background(), common.backupTimeout)
+	defer drillCancel()
+	backupName, err := backup.ResolveBackupName(drillCTX, blobClient, flags.Arg(0))
+	if err != nil {
+		setupLog.Error(err, "error finding backup")
+		os.Exit(1)
+	}
+
+	driller := backup.NewDriller(blobClient, cipher, common.spoolDirectory, logr.WithName("drill"))
+	result, err := driller.Drill(drillCTX, backupName)
+	if err != nil {
+		setupLog.Error(err, "error running restore drill")
+		common.syncLogger()
+		os.Exit(1)
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(result)
+	} else {
+		printDrillResult(result)
+	}
+
+	if !result.Passed() {
+		common.syncLogger()
+		os.Exit(1)
+	}
+}
+
+func printDrillResult(result *backup.DrillResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Backup:\t%s\n", result.Backup)
+	fmt.Fprintf(w, "Started At:\t%s\n", result.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Finished At:\t%s\n", result.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Restored Revision:\t%d\n", result.Revision)
+	fmt.Fprintf(w, "Passed:\t%t\n", result.Passed())
+	_ = w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ASSERTION\tPASSED\tMESSAGE")
+	for _, assertion := range result.Assertions {
+		fmt.Fprintf(w, "%s\t%t\t%s\n", assertion.Name, assertion.Passed, assertion.Message)
+	}
+	_ = w.Flush()
+
+	if len(result.KeyCounts) == 0 {
+		return
+	}
+
+	resources := make([]string, 0, len(result.KeyCounts))
+	for resource := range result.KeyCounts {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tKEYS")
+	for _, resource := range resources {
+		fmt.Fprintf(w, "%s\t%d\n", resource, result.KeyCounts[resource])
+	}
+	_ = w.Flush()
+}
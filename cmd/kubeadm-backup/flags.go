@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+)
+
+// commonFlags are the flags shared between sub commands
+type commonFlags struct {
+	logLevel int
+
+	// etcd flags
+	etcdEndpoint string
+	etcdCaFile   string
+	etcdKeyFile  string
+	etcdCertFile string
+
+	// kubeadm flags
+	kubeadmPKIDirectory string
+
+	// backup flags
+	spoolDirectory string
+	maxSpoolSize   int64
+	backupTimeout  time.Duration
+
+	// retention flags
+	minBackups         int
+	maxNewestBackupAge time.Duration
+
+	// blob flags
+	blobConfigFile string
+	destination    string
+	clusterName    string
+
+	// encryption flags
+	encryptionConfigFile string
+
+	zapLog *zap.Logger
+	log    logr.Logger
+}
+
+func (c *commonFlags) addLogFlags(flags *flag.FlagSet) {
+	flags.IntVar(&c.logLevel, "v", 0, "number for the log level verbosity")
+}
+
+func (c *commonFlags) addEtcdFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.etcdEndpoint, "etcd-endpoint", "http://127.0.0.1:2379", "etcd endpoint to connect to")
+	flags.StringVar(&c.etcdCaFile, "etcd-ca-file", "", "etcd ca to use")
+	flags.StringVar(&c.etcdKeyFile, "etcd-key-file", "", "etcd key to use")
+	flags.StringVar(&c.etcdCertFile, "etcd-certificate-file", "", "etcd certificate to use")
+}
+
+func (c *commonFlags) addPKIFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.kubeadmPKIDirectory, "kubeadm-pki-directory", "", "the directory for kubeadm pki")
+}
+
+func (c *commonFlags) addBackupFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.spoolDirectory, "spool-directory", "", "directory to spool the etcd snapshot to before uploading, defaults to the os temp directory")
+	flags.Int64Var(&c.maxSpoolSize, "max-spool-size", 0, "largest etcd snapshot in bytes that will be spooled, 0 means no limit")
+	flags.DurationVar(&c.backupTimeout, "backup-timeout", 30*time.Minute, "how long taking the etcd snapshot and uploading the backup may each take")
+}
+
+func (c *commonFlags) backupConfig(cipher crypto.Cipher) backup.BackupConfig {
+	return backup.BackupConfig{
+		KubeadmPKIDirectory: c.kubeadmPKIDirectory,
+		SpoolDirectory:      c.spoolDirectory,
+		MaxSpoolSize:        c.maxSpoolSize,
+		Timeout:             c.backupTimeout,
+		Cipher:              cipher,
+	}
+}
+
+func (c *commonFlags) addRetentionGuardFlags(flags *flag.FlagSet) {
+	flags.IntVar(&c.minBackups, "min-backups", 3, "how many of the newest backups are always kept when pruning")
+	flags.DurationVar(&c.maxNewestBackupAge, "max-newest-backup-age", 48*time.Hour, "do not prune a destination while its newest backup is older than this, 0 disables the check")
+}
+
+func (c *commonFlags) validateRetentionGuardFlags(setupLog logr.Logger) {
+	if c.minBackups < 0 || c.maxNewestBackupAge < 0 {
+		setupLog.Error(fmt.Errorf("min-backups and max-newest-backup-age can not be negative"), "invalid command flags")
+		os.Exit(1)
+	}
+}
+
+func (c *commonFlags) addBlobFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.blobConfigFile, "blob-config-file", "", "Path to blob storage configuration file")
+	flags.StringVar(&c.destination, "destination", "", "name of the blob storage destination to use, defaults to the first destination")
+	flags.StringVar(&c.clusterName, "cluster-name", "", "name of the cluster, backups are stored below <prefix>/<cluster-name>/ so clusters can share a bucket")
+}
+
+func (c *commonFlags) addEncryptionFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.encryptionConfigFile, "encryption-config-file", "", "Path to backup encryption configuration file, backups are not encrypted when empty")
+}
+
+// setupLogger creates the logger, it must be called after the flags are parsed
+func (c *commonFlags) setupLogger() logr.Logger {
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.DisableStacktrace = true
+	zapConfig.DisableCaller = true
+	zapConfig.Level = zap.NewAtomicLevelAt(zapcore.Level(0 - c.logLevel))
+
+	zapLog, err := zapConfig.Build()
+	if err != nil {
+		panic(fmt.Sprintf("error creating logger: %v", err))
+	}
+
+	c.zapLog = zapLog
+	c.log = zapr.NewLogger(zapLog)
+	return c.log
+}
+
+func (c *commonFlags) syncLogger() {
+	if c.zapLog != nil {
+		_ = c.zapLog.Sync()
+	}
+}
+
+func (c *commonFlags) validateEtcdFlags(setupLog logr.Logger) {
+	if (c.etcdKeyFile != "" && c.etcdCertFile == "") || (c.etcdKeyFile == "" && c.etcdCertFile != "") {
+		setupLog.Error(fmt.Errorf("both etcd-key-file and etcd-certificate-file must be given"), "invalid command flags")
+		os.Exit(1)
+	}
+}
+
+func (c *commonFlags) validatePKIFlags(setupLog logr.Logger) {
+	if c.kubeadmPKIDirectory == "" {
+		setupLog.Error(errFlagNotSet("kubeadm-pki-directory"), "invalid command flags")
+		os.Exit(1)
+	}
+}
+
+func (c *commonFlags) validateBlobFlags(setupLog logr.Logger) {
+	if c.blobConfigFile == "" {
+		setupLog.Error(errFlagNotSet("blob-config-file"), "invalid command flags")
+		os.Exit(1)
+	}
+
+	if c.clusterName != "" {
+		if err := blob.ValidateClusterName(c.clusterName); err != nil {
+			setupLog.Error(err, "invalid command flags")
+			os.Exit(1)
+		}
+	}
+}
+
+// createDestinations returns every destination in the blob config, or only the one named by the destination flag
+func (c *commonFlags) createDestinations(setupLog logr.Logger) []blob.Destination {
+	setupLog.Info("Creating Blob Clients")
+	destinations, err := blob.CreateDestinationsFromConfig(c.blobConfigFile, c.clusterName)
+	if err != nil {
+		setupLog.Error(err, "error creating blob clients from config")
+		os.Exit(1)
+	}
+
+	if c.destination == "" {
+		return destinations
+	}
+
+	for _, destination := range destinations {
+		if destination.Name == c.destination {
+			return []blob.Destination{destination}
+		}
+	}
+
+	setupLog.Error(fmt.Errorf("destination %s not found in blob config", c.destination), "invalid command flags")
+	os.Exit(1)
+	return nil
+}
+
+// createBlobClient returns the client of the destination named by the destination flag or the first destination
+func (c *commonFlags) createBlobClient(setupLog logr.Logger) blob.BlobClient {
+	destinations := c.createDestinations(setupLog)
+	for _, destination := range destinations[1:] {
+		destination.Client.Close()
+	}
+
+	return destinations[0].Client
+}
+
+func closeDestinations(destinations []blob.Destination) {
+	for _, destination := range destinations {
+		destination.Client.Close()
+	}
+}
+
+// createCipher returns nil when no encryption config file is given
+func (c *commonFlags) createCipher(setupLog logr.Logger) crypto.Cipher {
+	if c.encryptionConfigFile == "" {
+		return nil
+	}
+
+	setupLog.Info("Creating Cipher")
+	cipher, err := crypto.CreateCipherFromConfig(c.encryptionConfigFile)
+	if err != nil {
+		setupLog.Error(err, "error creating cipher from config")
+		os.Exit(1)
+	}
+
+	return cipher
+}
+
+func (c *commonFlags) createEtcdClient(setupLog logr.Logger) *etcd.Client {
+	setupLog.Info("Creating etcd Client")
+	etcdClient, err := etcd.NewEtcdClient(c.etcdEndpoint, c.etcdCaFile, c.etcdKeyFile, c.etcdCertFile)
+	if err != nil {
+		setupLog.Error(err, "Error creating etcd client")
+		os.Exit(1)
+	}
+
+	return etcdClient
+}
+
+func errFlagNotSet(name string) error {
+	return fmt.Errorf("%s not set", name)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+)
+
+func inspectCommand(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s inspect [flags] <backup|latest>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBlobFlags(flags)
+	common.addEncryptionFlags(flags)
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	common.validateBlobFlags(setupLog)
+
+	blobClient := common.createBlobClient(setupLog)
+	defer blobClient.Close()
+
+	cipher := common.createCipher(setupLog)
+
+	inspectCTX, inspectCancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer inspectCancel()
+	backupName, err := backup.ResolveBackupName(inspectCTX, blobClient, flags.Arg(0))
+	if err != nil {
+		setupLog.Error(err, "error finding backup")
+		os.Exit(1)
+	}
+
+	manifest, entries, err := backup.Inspect(inspectCTX, blobClient, cipher, backupName)
+	if err != nil {
+		setupLog.Error(err, "error inspecting backup")
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Backup:\t%s\n", backupName)
+	if manifest != nil {
+		fmt.Fprintf(w, "Format Version:\t%d\n", manifest.FormatVersion)
+		fmt.Fprintf(w, "Created At:\t%s\n", manifest.CreatedAt.Format(time.RFC3339))
+		fmt.Fprintf(w, "Hostname:\t%s\n", manifest.Hostname)
+		fmt.Fprintf(w, "Kubeadm Backup Version:\t%s\n", manifest.KubeadmBackupVersion)
+		fmt.Fprintf(w, "Etcd Endpoint:\t%s\n", manifest.Etcd.Endpoint)
+		fmt.Fprintf(w, "Etcd Cluster ID:\t%s\n", manifest.Etcd.ClusterID)
+		fmt.Fprintf(w, "Etcd Member ID:\t%s\n", manifest.Etcd.MemberID)
+		fmt.Fprintf(w, "Etcd Revision:\t%d\n", manifest.Etcd.Revision)
+		fmt.Fprintf(w, "Etcd Version:\t%s\n", manifest.Etcd.Version)
+		fmt.Fprintln(w, "Validated:\ttrue")
+	} else {
+		fmt.Fprintln(w, "Validated:\tfalse, backup has no manifest")
+	}
+	_ = w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tMODE\tMODIFIED")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", e.Name, e.Size, os.FileMode(e.Mode).Perm(), e.ModTime.Format(time.RFC3339))
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+)
+
+func listCommand(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBlobFlags(flags)
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+
+	blobClient := common.createBlobClient(setupLog)
+	defer blobClient.Close()
+
+	listCTX, listCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer listCancel()
+	backups, err := backup.ListBackups(listCTX, blobClient)
+	if err != nil {
+		setupLog.Error(err, "error listing backups")
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTIME\tAGE\tSIZE\tENCRYPTED\tPINNED\tPIN REASON")
+	for _, b := range backups {
+		pinReason := ""
+		if b.Pin != nil {
+			pinReason = b.Pin.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%t\t%t\t%s\n", b.Name, b.Time.Format(time.RFC3339), now.Sub(b.Time).Round(time.Second), b.Size, b.Encrypted, b.Pin != nil, pinReason)
+	}
+	_ = w.Flush()
+}
@@ -1,91 +1,58 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"github.com/rmb938/kubeadm-backup/pkg/metrics"
 	"os"
-	"time"
-
-	"github.com/go-logr/zapr"
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-
-	"github.com/rmb938/kubeadm-backup/pkg/backup"
-	"github.com/rmb938/kubeadm-backup/pkg/blob"
-	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+	"strings"
 )
 
-func main() {
-	logLevel := flag.Int("v", 0, "number for the log level verbosity")
-
-	// etcd flags
-	etcdEndpoint := flag.String("etcd-endpoint", "http://127.0.0.1:2379", "etcd endpoint to connect to")
-	etcdCaFile := flag.String("etcd-ca-file", "", "etcd ca to use")
-	etcdKeyFile := flag.String("etcd-key-file", "", "etcd key to use")
-	etcdCertFile := flag.String("etcd-certificate-file", "", "etcd certificate to use")
-
-	// kubeadm flags
-	kubeadmPKIDirectory := flag.String("kubeadm-pki-directory", "", "the directory for kubeadm pki")
-
-	// backup flags
-	backupDuration := flag.Duration("backup-interval", 1*time.Hour, "how often to take a backup")
-	backupTTL := flag.Duration("backup-ttl", (30*24)*time.Hour, "backup retention period")
-
-	// blob flags
-	blobConfigFile := flag.String("blob-config-file", "", "Path to blob storage configuration file")
-
-	flag.Parse()
-
-	zapConfig := zap.NewProductionConfig()
-	zapConfig.DisableStacktrace = true
-	zapConfig.DisableCaller = true
-	zapConfig.Level = zap.NewAtomicLevelAt(zapcore.Level(0 - *logLevel))
-
-	zapLog, err := zapConfig.Build()
-	if err != nil {
-		panic(fmt.Sprintf("error creating logger: %v", err))
-	}
-	defer zapLog.Sync()
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
 
-	logr := zapr.NewLogger(zapLog)
-	setupLog := logr.WithName("setup")
+var commands = []command{
+	{name: "run", description: "run the backup daemon, taking backups on an interval", run: runCommand},
+	{name: "once", description: "take a single backup and exit", run: onceCommand},
+	{name: "list", description: "list backups in blob storage", run: listCommand},
+	{name: "inspect", description: "show the contents of a backup", run: inspectCommand},
+	{name: "verify", description: "verify backups can be restored", run: verifyCommand},
+	{name: "drill", description: "restore a backup into a temporary etcd and check its data", run: drillCommand},
+	{name: "prune", description: "delete backups the retention policy does not keep", run: pruneCommand},
+	{name: "pin", description: "exempt a backup from retention", run: pinCommand},
+	{name: "unpin", description: "make retention apply to a pinned backup again", run: unpinCommand},
+	{name: "restore", description: "restore a backup onto this master", run: restoreCommand},
+}
 
-	if *blobConfigFile == "" {
-		setupLog.Error(fmt.Errorf("blob-config-file not set"), "invalid command flags")
-		os.Exit(1)
+func main() {
+	// no sub command or only flags keeps the original behavior of running the daemon
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1:])
+		return
 	}
 
-	if *kubeadmPKIDirectory == "" {
-		setupLog.Error(fmt.Errorf("kubeadm-pki-directory not set"), "invalid command flags")
-		os.Exit(1)
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(os.Args[2:])
+			return
+		}
 	}
 
-	if (*etcdKeyFile != "" && *etcdCertFile == "") || (*etcdKeyFile == "" && *etcdCertFile != "") {
-		setupLog.Error(fmt.Errorf("both etcd-key-file and etcd-certificate-file must be given"), "invalid command flags")
-		os.Exit(1)
+	if name != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
 	}
-
-	metrics.Log = logr.WithName("metrics")
-	go metrics.ServeMetrics()
-
-	setupLog.Info("Creating Blob Client")
-	blobClient, err := blob.CreateBlobClientFromConfig(*blobConfigFile)
-	if err != nil {
-		setupLog.Error(err, "error creating blob client from config")
-		os.Exit(1)
+	usage()
+	if name != "help" {
+		os.Exit(2)
 	}
-	defer blobClient.Close()
+}
 
-	setupLog.Info("Creating etcd Client")
-	etcdClient, err := etcd.NewEtcdClient(*etcdEndpoint, *etcdCaFile, *etcdKeyFile, *etcdCertFile)
-	if err != nil {
-		setupLog.Error(err, "Error creating etcd client")
-		os.Exit(1)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
 	}
-	defer etcdClient.Close()
-
-	backupTimer := backup.NewBackupTimer(blobClient, etcdClient, *kubeadmPKIDirectory, *backupDuration, *backupTTL, logr.WithName("backup-timer"))
-	backupTimer.Run()
-
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
+)
+
+// exit codes of the once command
+const (
+	exitBackupFailed        = 1
+	exitPruneFailed         = 3
+	exitPushFailed          = 4
+	exitBackupPartialFailed = 5
+)
+
+func onceCommand(args []string) {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addEtcdFlags(flags)
+	common.addPKIFlags(flags)
+	common.addBackupFlags(flags)
+	common.addBlobFlags(flags)
+	common.addRetentionGuardFlags(flags)
+	common.addEncryptionFlags(flags)
+
+	// backup flags
+	prune := flags.Bool("prune", true, "delete the backups the retention policy does not keep before taking the backup, like run does")
+	backupTTL := flags.Duration("backup-ttl", (30*24)*time.Hour, "backup retention period, used as keep_within when the blob config has no retention")
+	ignoreClusterIdentity := flags.Bool("ignore-cluster-identity", false, "upload and prune even when the cluster identity marker of a destination belongs to another cluster")
+
+	// pushgateway flags
+	pushgatewayURL := flags.String("pushgateway-url", "", "prometheus pushgateway to push metrics to, disabled when empty")
+	pushgatewayJob := flags.String("pushgateway-job", "kubeadm-backup", "job name to push metrics as")
+	pushgatewayGrouping := flags.String("pushgateway-grouping", "", "comma separated list of key=value labels to group the pushed metrics by")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+	common.validatePKIFlags(setupLog)
+	common.validateEtcdFlags(setupLog)
+	common.validateRetentionGuardFlags(setupLog)
+
+	grouping, err := parseGrouping(*pushgatewayGrouping)
+	if err != nil {
+		setupLog.Error(err, "invalid command flags")
+		os.Exit(1)
+	}
+
+	destinations := common.createDestinations(setupLog)
+	defer closeDestinations(destinations)
+
+	cipher := common.createCipher(setupLog)
+
+	etcdClient := common.createEtcdClient(setupLog)
+	defer etcdClient.Close()
+
+	backupTimer := backup.NewBackupTimer(destinations, etcdClient, common.backupConfig(cipher), backup.TimerConfig{
+		Retention:             retention.Policy{KeepWithin: *backupTTL},
+		MinBackups:            common.minBackups,
+		MaxNewestBackupAge:    common.maxNewestBackupAge,
+		IgnoreClusterIdentity: *ignoreClusterIdentity,
+	}, logr.WithName("backup"))
+
+	var pruneErr, backupErr error
+	if *prune {
+		pruneErr, backupErr = backupTimer.RunOnce(context.Background())
+	} else {
+		backupErr = backupTimer.Once(context.Background())
+	}
+
+	exitCode := 0
+	if pruneErr != nil {
+		setupLog.Error(pruneErr, "error cleaning backups")
+		exitCode = exitPruneFailed
+	}
+	var partialErr *backup.PartialBackupError
+	if errors.As(backupErr, &partialErr) {
+		setupLog.Error(backupErr, "backup was not uploaded to every destination")
+		exitCode = exitBackupPartialFailed
+	} else if backupErr != nil {
+		setupLog.Error(backupErr, "error taking backup")
+		exitCode = exitBackupFailed
+	}
+
+	if *pushgatewayURL != "" {
+		collectors := []prometheus.Collector{backup.BackupSuccess, backup.BackupDurationSeconds, backup.BackupPartialSuccess,
+			backup.BackupDestinationSuccess, backup.BackupDestinationLastSuccessfulTime, backup.ClusterIdentityMismatch}
+		if backupErr == nil {
+			collectors = append(collectors, backup.LastSuccessfulBackupTime, backup.BackupSizeBytes)
+		}
+		if *prune {
+			collectors = append(collectors, backup.ForeignObjects, backup.BackupDestinationStoredBackups, backup.BackupDestinationStoredBytes,
+				backup.PruneSuppressed)
+		}
+
+		pushCTX, pushCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer pushCancel()
+		if err := metrics.Push(pushCTX, *pushgatewayURL, *pushgatewayJob, grouping, collectors...); err != nil {
+			setupLog.Error(err, "error pushing metrics")
+			if exitCode == 0 {
+				exitCode = exitPushFailed
+			}
+		}
+	}
+
+	if exitCode != 0 {
+		common.syncLogger()
+		os.Exit(exitCode)
+	}
+}
+
+func parseGrouping(raw string) (map[string]string, error) {
+	grouping := map[string]string{}
+	if raw == "" {
+		return grouping, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid pushgateway grouping %q, expected key=value", pair)
+		}
+		grouping[name] = value
+	}
+
+	return grouping, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+)
+
+func pinCommand(args []string) {
+	flags := flag.NewFlagSet("pin", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s pin [flags] <backup|latest>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBlobFlags(flags)
+
+	reason := flags.String("reason", "", "why the backup is pinned, shown by list")
+
+	backupArg := parseWithBackupArg(flags, args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+
+	applyToBackup(common, setupLog, backupArg, "pinned backup", func(ctx context.Context, blobClient blob.BlobClient, backupName string) error {
+		return backup.PinBackup(ctx, blobClient, backupName, *reason)
+	})
+}
+
+func unpinCommand(args []string) {
+	flags := flag.NewFlagSet("unpin", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s unpin [flags] <backup|latest>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBlobFlags(flags)
+
+	backupArg := parseWithBackupArg(flags, args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+
+	applyToBackup(common, setupLog, backupArg, "unpinned backup", func(ctx context.Context, blobClient blob.BlobClient, backupName string) error {
+		return backup.UnpinBackup(ctx, blobClient, backupName)
+	})
+}
+
+// parseWithBackupArg parses flags given before and after the backup argument, so both pin -reason x <backup> and
+// pin <backup> -reason x work
+func parseWithBackupArg(flags *flag.FlagSet, args []string) string {
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	backupArg := flags.Arg(0)
+	_ = flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return backupArg
+}
+
+// applyToBackup applies a change to the backup in every destination holding it, latest is the newest backup of the
+// first destination
+func applyToBackup(common *commonFlags, setupLog logr.Logger, backupArg string, done string, apply func(ctx context.Context, blobClient blob.BlobClient, backupName string) error) {
+	destinations := common.createDestinations(setupLog)
+	defer closeDestinations(destinations)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	backupName, err := backup.ResolveBackupName(ctx, destinations[0].Client, backupArg)
+	if err != nil {
+		setupLog.Error(err, "error finding backup")
+		common.syncLogger()
+		os.Exit(1)
+	}
+
+	applied := 0
+	failed := false
+	for _, destination := range destinations {
+		log := setupLog.WithValues("backup", backupName, "destination", destination.Name)
+
+		err := apply(ctx, destination.Client, backupName)
+		if errors.Is(err, backup.ErrBackupNotFound) {
+			log.Info("backup is not in destination, skipping")
+			continue
+		}
+		if err != nil {
+			log.Error(err, "error changing backup")
+			failed = true
+			continue
+		}
+
+		applied++
+		log.Info(done)
+	}
+
+	if applied == 0 && !failed {
+		setupLog.Error(fmt.Errorf("backup %s not found in any destination", backupName), "error finding backup")
+		failed = true
+	}
+
+	if failed {
+		common.syncLogger()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
+)
+
+func pruneCommand(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addEtcdFlags(flags)
+	common.addBlobFlags(flags)
+	common.addRetentionGuardFlags(flags)
+
+	// backup flags
+	backupTTL := flags.Duration("backup-ttl", (30*24)*time.Hour, "backup retention period, used as keep_within when the blob config has no retention")
+	ignoreClusterIdentity := flags.Bool("ignore-cluster-identity", false, "upload and prune even when the cluster identity marker of a destination belongs to another cluster")
+	dryRun := flags.Bool("dry-run", false, "only log the backups that would be deleted")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+	common.validateEtcdFlags(setupLog)
+	common.validateRetentionGuardFlags(setupLog)
+
+	destinations := common.createDestinations(setupLog)
+	defer closeDestinations(destinations)
+
+	// etcd is only used to check the cluster identity of the destinations
+	etcdClient := common.createEtcdClient(setupLog)
+	defer etcdClient.Close()
+
+	backupTimer := backup.NewBackupTimer(destinations, etcdClient, backup.BackupConfig{}, backup.TimerConfig{
+		Retention:             retention.Policy{KeepWithin: *backupTTL},
+		MinBackups:            common.minBackups,
+		MaxNewestBackupAge:    common.maxNewestBackupAge,
+		IgnoreClusterIdentity: *ignoreClusterIdentity,
+	}, logr.WithName("prune"))
+	if err := backupTimer.Prune(context.Background(), *dryRun); err != nil {
+		setupLog.Error(err, "error pruning backups")
+		common.syncLogger()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+)
+
+func restoreCommand(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addPKIFlags(flags)
+	common.addBlobFlags(flags)
+	common.addEncryptionFlags(flags)
+	flags.StringVar(&common.kubeadmPKIDirectory, "pki-dir", "", "the directory for kubeadm pki to restore into, alias of kubeadm-pki-directory")
+
+	// restore flags
+	backupName := flags.String("backup", backup.LatestBackup, "name of the backup to restore or latest")
+	etcdDataDirectory := flags.String("data-dir", "", "the etcd data directory to restore into, must not exist")
+	overwritePKI := flags.Bool("overwrite-pki", false, "overwrite existing files in the kubeadm pki directory")
+
+	// etcd member flags
+	etcdName := flags.String("etcd-name", "default", "human-readable name for the restored etcd member")
+	etcdInitialCluster := flags.String("etcd-initial-cluster", "default=http://localhost:2380", "initial cluster configuration for the restored etcd member")
+	etcdInitialClusterToken := flags.String("etcd-initial-cluster-token", "etcd-cluster", "initial cluster token for the restored etcd member")
+	etcdInitialAdvertisePeerURLs := flags.String("etcd-initial-advertise-peer-urls", "http://localhost:2380", "comma separated list of peer urls for the restored etcd member")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+	common.validatePKIFlags(setupLog)
+
+	if *etcdDataDirectory == "" {
+		setupLog.Error(errFlagNotSet("data-dir"), "invalid command flags")
+		os.Exit(1)
+	}
+
+	blobClient := common.createBlobClient(setupLog)
+	defer blobClient.Close()
+
+	cipher := common.createCipher(setupLog)
+
+	restorer := backup.NewRestorer(blobClient, backup.RestoreConfig{
+		KubeadmPKIDirectory:     common.kubeadmPKIDirectory,
+		EtcdDataDirectory:       *etcdDataDirectory,
+		EtcdName:                *etcdName,
+		EtcdInitialCluster:      *etcdInitialCluster,
+		EtcdInitialClusterToken: *etcdInitialClusterToken,
+		EtcdPeerURLs:            strings.Split(*etcdInitialAdvertisePeerURLs, ","),
+		OverwritePKI:            *overwritePKI,
+		Cipher:                  cipher,
+	}, logr.WithName("restore"))
+
+	if err := restorer.Restore(*backupName); err != nil {
+		setupLog.Error(err, "error restoring backup")
+		common.syncLogger()
+		os.Exit(1)
+	}
+}
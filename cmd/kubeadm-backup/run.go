@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+	"github.com/rmb938/kubeadm-backup/pkg/leader"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
+	"github.com/rmb938/kubeadm-backup/pkg/schedule"
+)
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addEtcdFlags(flags)
+	common.addPKIFlags(flags)
+	common.addBackupFlags(flags)
+	common.addBlobFlags(flags)
+	common.addRetentionGuardFlags(flags)
+	common.addEncryptionFlags(flags)
+
+	// backup flags
+	backupDuration := flags.Duration("backup-interval", 1*time.Hour, "how often to take a backup, the first backup is due an interval after the newest existing backup")
+	backupSchedule := flags.String("backup-schedule", "", "cron expression of when to take backups like \"0 */4 * * *\", replaces backup-interval when set")
+	backupTimezone := flags.String("backup-timezone", "Local", "timezone of backup-schedule and backup-blackout-windows")
+	backupJitter := flags.Duration("backup-jitter", 0, "delay every backup by a random duration up to this")
+	backupBlackoutWindows := flags.String("backup-blackout-windows", "", "comma separated list of HH:MM-HH:MM times of day no backup is started in, like 02:00-03:00")
+	backupTTL := flags.Duration("backup-ttl", (30*24)*time.Hour, "backup retention period, used as keep_within when the blob config has no retention")
+	ignoreClusterIdentity := flags.Bool("ignore-cluster-identity", false, "upload and prune even when the cluster identity marker of a destination belongs to another cluster")
+	verifyInterval := flags.Duration("verify-interval", 0, "how often to verify the latest backup can be restored, 0 disables verification")
+
+	// leader election flags
+	leaderElect := flags.Bool("leader-elect", false, "elect a leader through etcd so only one of many instances takes backups, for running one instance per master")
+	leaderElectionKey := flags.String("leader-election-key", "/kubeadm-backup/leader", "etcd key prefix the instances campaign on")
+	leaderElectionTTL := flags.Duration("leader-election-ttl", 15*time.Second, "how long leadership is kept after the leader stopped refreshing its lease")
+	leaderElectionIdentity := flags.String("leader-election-identity", "", "name of this instance stored in the leader key, defaults to the hostname")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	common.validateBlobFlags(setupLog)
+	common.validatePKIFlags(setupLog)
+	common.validateEtcdFlags(setupLog)
+	common.validateRetentionGuardFlags(setupLog)
+
+	location, err := time.LoadLocation(*backupTimezone)
+	if err != nil {
+		setupLog.Error(fmt.Errorf("error loading backup-timezone: %w", err), "invalid command flags")
+		os.Exit(1)
+	}
+	blackouts, err := schedule.ParseWindows(*backupBlackoutWindows)
+	if err != nil {
+		setupLog.Error(fmt.Errorf("error parsing backup-blackout-windows: %w", err), "invalid command flags")
+		os.Exit(1)
+	}
+	scheduler, err := schedule.NewScheduler(schedule.Config{
+		Interval:  *backupDuration,
+		Cron:      *backupSchedule,
+		Location:  location,
+		Jitter:    *backupJitter,
+		Blackouts: blackouts,
+	}, logr.WithName("schedule"))
+	if err != nil {
+		setupLog.Error(err, "invalid command flags")
+		os.Exit(1)
+	}
+
+	if *leaderElect {
+		if *leaderElectionTTL < time.Second {
+			setupLog.Error(fmt.Errorf("leader-election-ttl must be at least 1s"), "invalid command flags")
+			os.Exit(1)
+		}
+		if *leaderElectionIdentity == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				setupLog.Error(err, "error getting hostname for leader-election-identity")
+				os.Exit(1)
+			}
+			*leaderElectionIdentity = hostname
+		}
+	}
+
+	metrics.Log = logr.WithName("metrics")
+	go metrics.ServeMetrics()
+
+	destinations := common.createDestinations(setupLog)
+	defer closeDestinations(destinations)
+
+	cipher := common.createCipher(setupLog)
+
+	etcdClient := common.createEtcdClient(setupLog)
+	defer etcdClient.Close()
+
+	backupTimer := backup.NewBackupTimer(destinations, etcdClient, common.backupConfig(cipher), backup.TimerConfig{
+		Schedule:       scheduler,
+		Retention:      retention.Policy{KeepWithin: *backupTTL},
+		VerifyInterval: *verifyInterval,
+
+		MinBackups:         common.minBackups,
+		MaxNewestBackupAge: common.maxNewestBackupAge,
+
+		IgnoreClusterIdentity: *ignoreClusterIdentity,
+	}, logr.WithName("backup-timer"))
+
+	if !*leaderElect {
+		backupTimer.Run(context.Background())
+		return
+	}
+
+	// only the leader reports the results of backups, a standby would report that it never took one
+	backup.ResultMetrics.SetOpen(false)
+	leader.NewElector(etcdClient, leader.Config{
+		Key:      *leaderElectionKey,
+		Identity: *leaderElectionIdentity,
+		TTL:      *leaderElectionTTL,
+	}, logr.WithName("leader-election")).Run(func(ctx context.Context) {
+		backup.ResultMetrics.SetOpen(true)
+		defer backup.ResultMetrics.SetOpen(false)
+		backupTimer.Run(ctx)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/backup"
+)
+
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s verify [flags] <backup|latest|-all|-random N>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	common := &commonFlags{}
+	common.addLogFlags(flags)
+	common.addBackupFlags(flags)
+	common.addBlobFlags(flags)
+	common.addEncryptionFlags(flags)
+
+	all := flags.Bool("all", false, "verify every backup")
+	random := flags.Int("random", 0, "verify this many randomly chosen backups")
+
+	_ = flags.Parse(args)
+
+	logr := common.setupLogger()
+	defer common.syncLogger()
+	setupLog := logr.WithName("setup")
+
+	selectors := 0
+	if *all {
+		selectors++
+	}
+	if *random > 0 {
+		selectors++
+	}
+	if flags.NArg() == 1 {
+		selectors++
+	}
+	if selectors != 1 || flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	common.validateBlobFlags(setupLog)
+
+	blobClient := common.createBlobClient(setupLog)
+	defer blobClient.Close()
+
+	cipher := common.createCipher(setupLog)
+
+	listCTX, listCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer listCancel()
+
+	var backupNames []string
+	if flags.NArg() == 1 {
+		backupName, err := backup.ResolveBackupName(listCTX, blobClient, flags.Arg(0))
+		if err != nil {
+			setupLog.Error(err, "error finding backup")
+			os.Exit(1)
+		}
+		backupNames = append(backupNames, backupName)
+	} else {
+		backups, err := backup.ListBackups(listCTX, blobClient)
+		if err != nil {
+			setupLog.Error(err, "error listing backups")
+			os.Exit(1)
+		}
+
+		if *random > 0 {
+			rand.Shuffle(len(backups), func(i, j int) {
+				backups[i], backups[j] = backups[j], backups[i]
+			})
+			if *random < len(backups) {
+				backups = backups[:*random]
+			}
+		}
+
+		for _, b := range backups {
+			backupNames = append(backupNames, b.Name)
+		}
+	}
+
+	verifier := backup.NewVerifier(blobClient, cipher, common.spoolDirectory, logr.WithName("verify"))
+
+	failed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "BACKUP\tVALID\tREVISION\tKEYS\tERROR")
+	for _, backupName := range backupNames {
+		verifyCTX, verifyCancel := context.WithTimeout(context.Background(), common.backupTimeout)
+		result := verifier.Verify(verifyCTX, backupName)
+		verifyCancel()
+
+		revision, keys := "", ""
+		if result.Snapshot != nil {
+			revision = fmt.Sprint(result.Snapshot.Revision)
+			keys = fmt.Sprint(result.Snapshot.TotalKey)
+		}
+
+		errMessage := ""
+		if result.Err != nil {
+			failed = true
+			errMessage = result.Err.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\n", backupName, result.Err == nil, revision, keys, errMessage)
+	}
+	_ = w.Flush()
+
+	if failed {
+		common.syncLogger()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,78 @@
+#!/usr/bin/env python3
+"""Example kubeadm-backup EXEC plugin that stores backups in a local directory.
+
+The blob storage config passes the directory to the plugin:
+
+    type: EXEC
+    config:
+      command: /usr/local/bin/directory-plugin.py
+      config:
+        directory: /var/backups/kubeadm
+"""
+import datetime
+import json
+import os
+import shutil
+import sys
+import tempfile
+
+
+def respond(error=None):
+    response = {"error": error} if error else {}
+    sys.stdout.buffer.write(json.dumps(response).encode() + b"\n")
+    sys.stdout.buffer.flush()
+
+
+def main():
+    request = json.loads(sys.stdin.buffer.readline())
+    if request["protocol_version"] != 1:
+        respond("unsupported protocol version %d" % request["protocol_version"])
+        return 1
+
+    directory = request["config"]["directory"]
+    operation = request["operation"]
+    path = os.path.join(directory, request.get("object", ""))
+
+    try:
+        if operation == "create":
+            os.makedirs(os.path.dirname(path), exist_ok=True)
+            # the object is only renamed into place once stdin reached EOF
+            with tempfile.NamedTemporaryFile(dir=os.path.dirname(path), prefix=".tmp-", delete=False) as f:
+                shutil.copyfileobj(sys.stdin.buffer, f)
+                f.flush()
+                os.fsync(f.fileno())
+            os.rename(f.name, path)
+            respond()
+        elif operation == "read":
+            with open(path, "rb") as f:
+                respond()
+                shutil.copyfileobj(f, sys.stdout.buffer)
+        elif operation == "list":
+            prefix = request.get("prefix", "")
+            respond()
+            for root, _, files in os.walk(directory):
+                for name in files:
+                    if name.startswith(".tmp-"):
+                        continue
+                    objectName = os.path.relpath(os.path.join(root, name), directory)
+                    if not objectName.startswith(prefix):
+                        continue
+                    stat = os.stat(os.path.join(root, name))
+                    lastModified = datetime.datetime.fromtimestamp(stat.st_mtime, datetime.timezone.utc)
+                    entry = {"name": objectName, "size": stat.st_size, "last_modified": lastModified.isoformat()}
+                    sys.stdout.buffer.write(json.dumps(entry).encode() + b"\n")
+        elif operation == "delete":
+            os.remove(path)
+            respond()
+        else:
+            respond("unknown operation %s" % operation)
+            return 1
+    except OSError as e:
+        respond(str(e))
+        return 1
+
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
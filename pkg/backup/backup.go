@@ -2,9 +2,11 @@ package backup
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -12,7 +14,9 @@ import (
 	"time"
 
 	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
 	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
 )
 
 var pkiFiles = []string{
@@ -26,84 +30,305 @@ var pkiFiles = []string{
 	path.Join("etcd", "ca.key"),
 }
 
+type BackupConfig struct {
+	KubeadmPKIDirectory string
+
+	// SpoolDirectory is where the etcd snapshot is written before being archived, defaults to the os temp directory
+	SpoolDirectory string
+	// MaxSpoolSize is the largest etcd snapshot that will be spooled, 0 means no limit
+	MaxSpoolSize int64
+
+	// Timeout is how long taking the snapshot and uploading the archive may each take
+	Timeout time.Duration
+
+	// Cipher encrypts the archive before it is uploaded, nil means no encryption
+	Cipher crypto.Cipher
+}
+
 type backup struct {
-	blobClient blob.BlobClient
-	etcdClient *etcd.Client
+	destinations []blob.Destination
+	etcdClient   *etcd.Client
+
+	config BackupConfig
+}
 
-	kubeadmPKIDirectory string
+// pkiFileContent is a kubeadm pki file read into memory so it can be hashed for the manifest
+type pkiFileContent struct {
+	name    string
+	mode    int64
+	modTime time.Time
+	data    []byte
 }
 
-func (b *backup) Take() error {
+// Take snapshots etcd and uploads it together with the kubeadm pki to every destination, returning the result of each
+// upload. A *PartialBackupError is returned when only some of the uploads succeeded. The uploads are aborted when ctx
+// is cancelled.
+func (b *backup) Take(ctx context.Context) ([]UploadResult, error) {
+	memoryMonitor := startMemoryMonitor()
+	defer func() {
+		BackupPeakMemoryBytes.Set(float64(memoryMonitor.Stop()))
+	}()
 
 	// sync etcd endpoints
-	syncCTX, syncCTXCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	syncCTX, syncCTXCancel := context.WithTimeout(ctx, 10*time.Second)
 	defer syncCTXCancel()
 	err := b.etcdClient.Sync(syncCTX)
 	if err != nil {
-		return fmt.Errorf("error syncing etcd endpoints: %w", err)
+		return nil, fmt.Errorf("error syncing etcd endpoints: %w", err)
+	}
+
+	statusCTX, statusCTXCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer statusCTXCancel()
+	etcdStatus, err := b.etcdClient.Status(statusCTX)
+	if err != nil {
+		return nil, fmt.Errorf("error getting etcd status: %w", err)
+	}
+
+	// spool the etcd snapshot to disk, tar header needs a size
+	snapshotFile, snapshotSize, snapshotSHA256, err := b.spoolSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(snapshotFile.Name())
+	defer snapshotFile.Close()
+
+	pkiFileContents, err := b.readPKIFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	manifest, err := b.buildManifest(now, etcdStatus, snapshotSize, snapshotSHA256, pkiFileContents)
+	if err != nil {
+		return nil, err
+	}
+
+	// stream the archive into every destination at once
+	objectName := backupObjectName(now, b.config.Cipher)
+
+	blobCreateCTX, blobCreateCTXCancel := context.WithTimeout(ctx, b.config.Timeout)
+	defer blobCreateCTXCancel()
+
+	fanout := &fanoutWriter{}
+	createErrChans := make([]chan error, len(b.destinations))
+	for i, destination := range b.destinations {
+		pipeReader, pipeWriter := io.Pipe()
+		fanout.writers = append(fanout.writers, newDestinationWriter(pipeWriter))
+
+		createErrChans[i] = make(chan error, 1)
+		go func(destination blob.Destination, createErrChan chan<- error) {
+			err := destination.Client.Create(blobCreateCTX, objectName, pipeReader)
+			// unblock the archive writer if the upload stopped reading early
+			pipeReader.CloseWithError(fmt.Errorf("blob upload finished"))
+			createErrChan <- err
+		}(destination, createErrChans[i])
+	}
+
+	archiveWriter := &countingWriter{writer: fanout}
+	archiveErr := b.writeEncryptedArchive(ctx, archiveWriter, manifest, snapshotFile, snapshotSize, pkiFileContents)
+	fanout.Close(archiveErr)
+
+	results := make([]UploadResult, len(b.destinations))
+	for i, destination := range b.destinations {
+		createErr := <-createErrChans[i]
+		if createErr == nil && fanout.writers[i].err != nil {
+			createErr = fmt.Errorf("upload finished before the whole backup was written: %w", fanout.writers[i].err)
+		}
+
+		results[i] = UploadResult{Destination: destination.Name, Size: archiveWriter.count}
+		if createErr != nil {
+			results[i].Err = fmt.Errorf("error uploading backup %s: %w", objectName, createErr)
+		}
 	}
 
+	if archiveErr != nil {
+		return results, archiveErr
+	}
+
+	return results, uploadError(results)
+}
+
+func (b *backup) spoolSnapshot(ctx context.Context) (*os.File, int64, string, error) {
 	// take etcd snapshot
-	snapshotCTX, snapshotCTXCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	snapshotCTX, snapshotCTXCancel := context.WithTimeout(ctx, b.config.Timeout)
 	defer snapshotCTXCancel()
 	snapshotReader, err := b.etcdClient.Snapshot(snapshotCTX)
 	if err != nil {
-		return fmt.Errorf("error trying to snapshot etcd: %w", err)
+		return nil, 0, "", fmt.Errorf("error trying to snapshot etcd: %w", err)
 	}
 	defer snapshotReader.Close()
-	// write snapshot to buffer, tar header needs a size
-	snapshotBytesBuff := &bytes.Buffer{}
-	if _, err := io.Copy(snapshotBytesBuff, snapshotReader); err != nil {
-		return fmt.Errorf("error copying etcd snapshot data to a buffer: %w", err)
+
+	snapshotFile, err := os.CreateTemp(b.config.SpoolDirectory, "kubeadm-backup-snapshot-*.db")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("error creating etcd snapshot spool file: %w", err)
+	}
+
+	var reader io.Reader = snapshotReader
+	if b.config.MaxSpoolSize > 0 {
+		// read one byte past the limit so an oversized snapshot can be detected
+		reader = io.LimitReader(snapshotReader, b.config.MaxSpoolSize+1)
+	}
+
+	snapshotHash := sha256.New()
+	snapshotSize, err := io.Copy(io.MultiWriter(snapshotFile, snapshotHash), reader)
+	if err == nil && b.config.MaxSpoolSize > 0 && snapshotSize > b.config.MaxSpoolSize {
+		err = fmt.Errorf("etcd snapshot is larger than the max spool size of %d bytes", b.config.MaxSpoolSize)
+	}
+	if err == nil {
+		_, err = snapshotFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		snapshotFile.Close()
+		os.Remove(snapshotFile.Name())
+		return nil, 0, "", fmt.Errorf("error spooling etcd snapshot to %s: %w", snapshotFile.Name(), err)
+	}
+
+	return snapshotFile, snapshotSize, hex.EncodeToString(snapshotHash.Sum(nil)), nil
+}
+
+func (b *backup) readPKIFiles() ([]pkiFileContent, error) {
+	var contents []pkiFileContent
+
+	for _, pkiFile := range pkiFiles {
+		pkiFilePath := path.Join(b.config.KubeadmPKIDirectory, pkiFile)
+		stat, err := os.Stat(pkiFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("error stat pki file %s: %w", pkiFilePath, err)
+		}
+
+		data, err := os.ReadFile(pkiFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pki file %s: %w", pkiFilePath, err)
+		}
+
+		contents = append(contents, pkiFileContent{
+			name:    path.Join("certs", pkiFile),
+			mode:    int64(stat.Mode()),
+			modTime: stat.ModTime(),
+			data:    data,
+		})
+	}
+
+	return contents, nil
+}
+
+func (b *backup) buildManifest(now time.Time, etcdStatus *etcd.Status, snapshotSize int64, snapshotSHA256 string, pkiFileContents []pkiFileContent) ([]byte, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("error getting hostname: %w", err)
+	}
+
+	manifest := Manifest{
+		FormatVersion:        manifestFormatVersion,
+		CreatedAt:            now.UTC(),
+		KubeadmBackupVersion: version.Version,
+		Hostname:             hostname,
+		Etcd: ManifestEtcd{
+			Endpoint:  etcdStatus.Endpoint,
+			MemberID:  fmt.Sprintf("%x", etcdStatus.MemberID),
+			ClusterID: fmt.Sprintf("%x", etcdStatus.ClusterID),
+			Revision:  etcdStatus.Revision,
+			Version:   etcdStatus.Version,
+		},
+		Files: []ManifestFile{
+			{
+				Name:   snapshotFileName,
+				Size:   snapshotSize,
+				SHA256: snapshotSHA256,
+			},
+		},
+	}
+
+	for _, content := range pkiFileContents {
+		sum := sha256.Sum256(content.data)
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Name:   content.name,
+			Size:   int64(len(content.data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling backup manifest: %w", err)
+	}
+
+	return rawManifest, nil
+}
+
+func (b *backup) writeEncryptedArchive(ctx context.Context, writer io.Writer, manifest []byte, snapshotReader io.Reader, snapshotSize int64, pkiFileContents []pkiFileContent) error {
+	if b.config.Cipher == nil {
+		return b.writeArchive(writer, manifest, snapshotReader, snapshotSize, pkiFileContents)
+	}
+
+	encryptCTX, encryptCancel := context.WithTimeout(ctx, b.config.Timeout)
+	defer encryptCancel()
+	encryptWriter, err := b.config.Cipher.Encrypt(encryptCTX, writer)
+	if err != nil {
+		return fmt.Errorf("error starting encryption of backup: %w", err)
+	}
+
+	if err := b.writeArchive(encryptWriter, manifest, snapshotReader, snapshotSize, pkiFileContents); err != nil {
+		return err
 	}
 
-	// create backup buff, gzip and tar writers
-	var buf bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buf)
+	if err := encryptWriter.Close(); err != nil {
+		return fmt.Errorf("error closing encryption of backup: %w", err)
+	}
+
+	return nil
+}
+
+func (b *backup) writeArchive(writer io.Writer, manifest []byte, snapshotReader io.Reader, snapshotSize int64, pkiFileContents []pkiFileContent) error {
+	gzipWriter := gzip.NewWriter(writer)
 	tarWriter := tar.NewWriter(gzipWriter)
 
+	// the manifest is always the first file so readers can validate everything after it
+	manifestHdr := &tar.Header{
+		Name:    manifestFileName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	}
+	err := tarWriter.WriteHeader(manifestHdr)
+	if err != nil {
+		return fmt.Errorf("error writing manifest header to tar: %w", err)
+	}
+	if _, err = tarWriter.Write(manifest); err != nil {
+		return fmt.Errorf("error writing manifest to tar: %w", err)
+	}
+
 	// write etcd snapshot to tar
 	snapshotHdr := &tar.Header{
-		Name:    "snapshot.db",
+		Name:    snapshotFileName,
 		Mode:    0600,
-		Size:    int64(len(snapshotBytesBuff.Bytes())),
+		Size:    snapshotSize,
 		ModTime: time.Now(),
 	}
 	err = tarWriter.WriteHeader(snapshotHdr)
 	if err != nil {
 		return fmt.Errorf("error writing etcd snapshot header to tar: %w", err)
 	}
-	if _, err = io.Copy(tarWriter, snapshotBytesBuff); err != nil {
+	if _, err = io.Copy(tarWriter, snapshotReader); err != nil {
 		return fmt.Errorf("error writing etcd snapshot data to tar: %w", err)
 	}
 
 	// backup pki
-	for _, pkiFile := range pkiFiles {
-		pkiFilePath := path.Join(b.kubeadmPKIDirectory, pkiFile)
-		f, err := os.Open(pkiFilePath)
-		if err != nil {
-			return fmt.Errorf("error opening pki file %s: %w", pkiFilePath, err)
-		}
-
-		stat, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("error stat pki file %s: %w", pkiFilePath, err)
-		}
-
+	for _, content := range pkiFileContents {
 		pkiFileHeader := &tar.Header{
-			Name:    path.Join("certs", pkiFile),
-			Size:    stat.Size(),
-			Mode:    int64(stat.Mode()),
-			ModTime: stat.ModTime(),
+			Name:    content.name,
+			Size:    int64(len(content.data)),
+			Mode:    content.mode,
+			ModTime: content.modTime,
 		}
 
 		err = tarWriter.WriteHeader(pkiFileHeader)
 		if err != nil {
-			return fmt.Errorf("error writing pki file %s header to tar %w", pkiFile, err)
+			return fmt.Errorf("error writing pki file %s header to tar %w", content.name, err)
 		}
 
-		if _, err = io.Copy(tarWriter, f); err != nil {
-			return fmt.Errorf("error writing pki file %s to tar: %w", pkiFile, err)
+		if _, err = tarWriter.Write(content.data); err != nil {
+			return fmt.Errorf("error writing pki file %s to tar: %w", content.name, err)
 		}
 	}
 
@@ -118,11 +343,17 @@ func (b *backup) Take() error {
 		return fmt.Errorf("error closing gzip: %w", err)
 	}
 
-	// create backup
-	now := time.Now()
-	objectName := fmt.Sprintf("backup-%v.tar.gz", now.Format(time.RFC3339Nano))
+	return nil
+}
 
-	blobCreateCTX, blobCreateCTXCancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer blobCreateCTXCancel()
-	return b.blobClient.Create(blobCreateCTX, objectName, &buf)
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.count += int64(n)
+	return n, err
 }
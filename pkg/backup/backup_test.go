@@ -0,0 +1,286 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/fs"
+	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+)
+
+// startTestEtcd runs a single member etcd for the test and returns its client url
+func startTestEtcd(t *testing.T) string {
+	t.Helper()
+
+	peerURL, clientURL, err := localURLs()
+	if err != nil {
+		t.Fatalf("error picking etcd urls: %v", err)
+	}
+
+	driller := NewDriller(nil, nil, "", logr.Discard())
+	etcdServer, err := driller.startEtcd(t.TempDir(), peerURL, clientURL)
+	if err != nil {
+		t.Fatalf("error starting etcd: %v", err)
+	}
+	t.Cleanup(etcdServer.Close)
+
+	return clientURL.String()
+}
+
+// writeTestPKI writes a self signed certificate and key for every kubeadm pki file
+func writeTestPKI(t *testing.T, directory string) map[string][]byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("error marshaling public key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	contents := map[string][]byte{}
+	for _, pkiFile := range pkiFiles {
+		var data []byte
+		switch filepath.Ext(pkiFile) {
+		case ".crt":
+			data = certPEM
+		case ".key":
+			data = keyPEM
+		case ".pub":
+			data = publicKeyPEM
+		}
+
+		pkiFilePath := filepath.Join(directory, filepath.FromSlash(pkiFile))
+		if err := os.MkdirAll(filepath.Dir(pkiFilePath), 0700); err != nil {
+			t.Fatalf("error creating pki directory: %v", err)
+		}
+		if err := os.WriteFile(pkiFilePath, data, 0600); err != nil {
+			t.Fatalf("error writing pki file %s: %v", pkiFile, err)
+		}
+		contents[pkiFile] = data
+	}
+
+	return contents
+}
+
+// takeTestBackup takes a backup of an etcd with a few keys and a test pki, it returns the blob client the backup was
+// uploaded to and the contents of the pki files
+func takeTestBackup(t *testing.T) (blob.BlobClient, map[string][]byte) {
+	t.Helper()
+
+	endpoint := startTestEtcd(t)
+
+	etcdClient, err := etcd.NewEtcdClient(endpoint, "", "", "")
+	if err != nil {
+		t.Fatalf("error creating etcd client: %v", err)
+	}
+	defer etcdClient.Close()
+
+	rawClient, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}})
+	if err != nil {
+		t.Fatalf("error creating etcd client: %v", err)
+	}
+	defer rawClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		if _, err := rawClient.Put(ctx, fmt.Sprintf("/registry/test/%d", i), "value"); err != nil {
+			t.Fatalf("error writing to etcd: %v", err)
+		}
+	}
+
+	pkiDirectory := t.TempDir()
+	pkiContents := writeTestPKI(t, pkiDirectory)
+
+	blobClient, err := fs.NewBlobClient([]byte(fmt.Sprintf("directory: %s", t.TempDir())))
+	if err != nil {
+		t.Fatalf("error creating blob client: %v", err)
+	}
+
+	b := &backup{
+		destinations: []blob.Destination{{Name: "fs", Client: blobClient}},
+		etcdClient:   etcdClient,
+		config: BackupConfig{
+			KubeadmPKIDirectory: pkiDirectory,
+			SpoolDirectory:      t.TempDir(),
+			Timeout:             time.Minute,
+		},
+	}
+
+	results, err := b.Take(context.Background())
+	if err != nil {
+		t.Fatalf("error taking backup: %v", err)
+	}
+	if len(results) != 1 || results[0].Err != nil || results[0].Size == 0 {
+		t.Fatalf("unexpected upload results %+v", results)
+	}
+
+	return blobClient, pkiContents
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	blobClient, pkiContents := takeTestBackup(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	catalog, err := LoadCatalog(ctx, blobClient)
+	if err != nil {
+		t.Fatalf("error loading catalog: %v", err)
+	}
+	if len(catalog.Backups) != 1 {
+		t.Fatalf("expected 1 backup, found %d", len(catalog.Backups))
+	}
+	backupName := catalog.Backups[0].Name
+
+	result := NewVerifier(blobClient, nil, t.TempDir(), logr.Discard()).Verify(ctx, backupName)
+	if result.Err != nil {
+		t.Fatalf("backup failed verification: %v", result.Err)
+	}
+	if result.Snapshot == nil || result.Snapshot.TotalKey < 10 {
+		t.Errorf("unexpected snapshot status %+v", result.Snapshot)
+	}
+
+	restorePKIDirectory := t.TempDir()
+	restoreDataDirectory := filepath.Join(t.TempDir(), "etcd")
+	err = NewRestorer(blobClient, RestoreConfig{
+		KubeadmPKIDirectory:     restorePKIDirectory,
+		EtcdDataDirectory:       restoreDataDirectory,
+		EtcdName:                "default",
+		EtcdInitialCluster:      "default=http://127.0.0.1:2380",
+		EtcdInitialClusterToken: "etcd-cluster",
+		EtcdPeerURLs:            []string{"http://127.0.0.1:2380"},
+	}, logr.Discard()).Restore(LatestBackup)
+	if err != nil {
+		t.Fatalf("error restoring backup: %v", err)
+	}
+
+	for pkiFile, expected := range pkiContents {
+		data, err := os.ReadFile(filepath.Join(restorePKIDirectory, filepath.FromSlash(pkiFile)))
+		if err != nil {
+			t.Errorf("error reading restored pki file %s: %v", pkiFile, err)
+			continue
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("restored pki file %s does not match the original", pkiFile)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(restoreDataDirectory, "member", "snap", "db")); err != nil {
+		t.Errorf("restored etcd data directory has no database: %v", err)
+	}
+}
+
+func TestRestoreFailedEtcdRestoreLeavesPKIUntouched(t *testing.T) {
+	blobClient, _ := takeTestBackup(t)
+
+	restorePKIDirectory := t.TempDir()
+	existing := filepath.Join(restorePKIDirectory, "existing.crt")
+	if err := os.WriteFile(existing, []byte("existing"), 0600); err != nil {
+		t.Fatalf("error writing existing pki file: %v", err)
+	}
+
+	restoreDataDirectory := filepath.Join(t.TempDir(), "etcd")
+	err := NewRestorer(blobClient, RestoreConfig{
+		KubeadmPKIDirectory: restorePKIDirectory,
+		EtcdDataDirectory:   restoreDataDirectory,
+		// the member is not part of the initial cluster so the etcd restore fails
+		EtcdName:                "default",
+		EtcdInitialCluster:      "other=http://127.0.0.1:2380",
+		EtcdInitialClusterToken: "etcd-cluster",
+		EtcdPeerURLs:            []string{"http://127.0.0.1:2380"},
+	}, logr.Discard()).Restore(LatestBackup)
+	if err == nil {
+		t.Fatalf("expected the restore to fail")
+	}
+
+	entries, err := os.ReadDir(restorePKIDirectory)
+	if err != nil {
+		t.Fatalf("error reading pki directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "existing.crt" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("pki directory contains %v after a failed restore", names)
+	}
+}
+
+func TestRestoreExistingPKIFileRestoresNothing(t *testing.T) {
+	blobClient, pkiContents := takeTestBackup(t)
+
+	restorePKIDirectory := t.TempDir()
+	var pkiFile string
+	for pkiFile = range pkiContents {
+		break
+	}
+	existing := filepath.Join(restorePKIDirectory, filepath.FromSlash(pkiFile))
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("error creating pki directory: %v", err)
+	}
+	if err := os.WriteFile(existing, []byte("existing"), 0600); err != nil {
+		t.Fatalf("error writing existing pki file: %v", err)
+	}
+
+	restoreDataDirectory := filepath.Join(t.TempDir(), "etcd")
+	err := NewRestorer(blobClient, RestoreConfig{
+		KubeadmPKIDirectory:     restorePKIDirectory,
+		EtcdDataDirectory:       restoreDataDirectory,
+		EtcdName:                "default",
+		EtcdInitialCluster:      "default=http://127.0.0.1:2380",
+		EtcdInitialClusterToken: "etcd-cluster",
+		EtcdPeerURLs:            []string{"http://127.0.0.1:2380"},
+	}, logr.Discard()).Restore(LatestBackup)
+	if err == nil {
+		t.Fatalf("expected the restore to fail")
+	}
+
+	if _, err := os.Stat(restoreDataDirectory); !os.IsNotExist(err) {
+		t.Errorf("etcd data directory exists after a failed restore: %v", err)
+	}
+	data, err := os.ReadFile(existing)
+	if err != nil || string(data) != "existing" {
+		t.Errorf("existing pki file was changed: %q %v", data, err)
+	}
+}
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+)
+
+// Backups are named backup-<time>.tar.gz, time is when the backup was taken formatted as RFC 3339 with as many
+// fractional seconds as needed. Encrypted backups have the suffix of their cipher appended, backup-<time>.tar.gz.age
+// for age and backup-<time>.tar.gz.enc for envelope encryption with a key file or Vault. Backups taken before
+// encryption existed only differ by having no cipher suffix.
+const (
+	backupObjectPrefix = "backup-"
+	backupObjectSuffix = ".tar.gz"
+)
+
+// encryptedObjectSuffixes are the suffixes the supported ciphers add to backups
+var encryptedObjectSuffixes = []string{".age", ".enc"}
+
+var (
+	// ForeignObjects is a prometheus metric which is a Gauge of how many objects in each destination were not written
+	// by kubeadm-backup
+	ForeignObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_foreign_objects",
+		Help: "How many objects in the destination are not backups or metadata written by kubeadm-backup.",
+	}, []string{"destination"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(ForeignObjects)
+}
+
+// backupObjectName returns the name of a backup taken at backupTime
+func backupObjectName(backupTime time.Time, cipher crypto.Cipher) string {
+	objectName := backupObjectPrefix + backupTime.Format(time.RFC3339Nano) + backupObjectSuffix
+	if cipher != nil {
+		objectName += cipher.Suffix()
+	}
+	return objectName
+}
+
+// parseBackupObjectName returns when a backup was taken and the suffix of the cipher it was encrypted with
+func parseBackupObjectName(objectName string) (time.Time, string, error) {
+	name, ok := strings.CutPrefix(objectName, backupObjectPrefix)
+	if !ok {
+		return time.Time{}, "", errors.New("object is not a backup")
+	}
+
+	encryptedSuffix := ""
+	for _, suffix := range encryptedObjectSuffixes {
+		if trimmed, ok := strings.CutSuffix(name, suffix); ok {
+			encryptedSuffix = suffix
+			name = trimmed
+			break
+		}
+	}
+
+	name, ok = strings.CutSuffix(name, backupObjectSuffix)
+	if !ok {
+		return time.Time{}, "", errors.New("object is not a backup")
+	}
+
+	objectTime, err := time.Parse(time.RFC3339Nano, name)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("object is not a backup, invalid time: %w", err)
+	}
+
+	return objectTime, encryptedSuffix, nil
+}
+
+// isMetadataObject returns whether an object is written by kubeadm-backup next to the backups
+func isMetadataObject(objectName string) bool {
+	if objectName == clusterIdentityObjectName {
+		return true
+	}
+	_, ok := parsePinObjectName(objectName)
+	return ok
+}
+
+// Catalog is everything kubeadm-backup found in a blob storage
+type Catalog struct {
+	// Backups are sorted from oldest to newest
+	Backups []BackupInfo
+	// ForeignObjects are the names of objects that are neither backups nor metadata of kubeadm-backup
+	ForeignObjects []string
+}
+
+// LoadCatalog lists every object in blob storage and sorts them into backups and foreign objects, a foreign object
+// never fails loading the catalog
+func LoadCatalog(ctx context.Context, blobClient blob.BlobClient) (*Catalog, error) {
+	catalog := &Catalog{}
+	pins := map[string]bool{}
+
+	it := blobClient.List(ctx, blob.ListOptions{})
+	for {
+		obj, err := it.Next()
+		if err == blob.IteratorDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing backups: %w", err)
+		}
+
+		if backupName, ok := parsePinObjectName(obj.Key); ok {
+			pins[backupName] = true
+			continue
+		}
+		if isMetadataObject(obj.Key) {
+			continue
+		}
+
+		objectTime, encryptedSuffix, err := parseBackupObjectName(obj.Key)
+		if err != nil {
+			catalog.ForeignObjects = append(catalog.ForeignObjects, obj.Key)
+			continue
+		}
+
+		catalog.Backups = append(catalog.Backups, BackupInfo{
+			Name:         obj.Key,
+			Time:         objectTime,
+			Encrypted:    encryptedSuffix != "",
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+	}
+
+	sort.Slice(catalog.Backups, func(i, j int) bool {
+		return catalog.Backups[i].Time.Before(catalog.Backups[j].Time)
+	})
+
+	// pins of backups that no longer exist are left alone
+	for i := range catalog.Backups {
+		if !pins[catalog.Backups[i].Name] {
+			continue
+		}
+
+		pin, err := readPin(ctx, blobClient, catalog.Backups[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		catalog.Backups[i].Pin = pin
+	}
+
+	return catalog, nil
+}
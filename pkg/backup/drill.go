@@ -0,0 +1,266 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/v3client"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+)
+
+const (
+	drillMemberName = "drill"
+	registryPrefix  = "/registry/"
+
+	kubeSystemNamespaceKey    = "/registry/namespaces/kube-system"
+	kubeadmConfigConfigMapKey = "/registry/configmaps/kube-system/kubeadm-config"
+)
+
+// DrillAssertion is a single check made against the restored etcd
+type DrillAssertion struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// DrillResult is the evidence of a restore drill
+type DrillResult struct {
+	Backup     string           `json:"backup"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Manifest   *Manifest        `json:"manifest,omitempty"`
+	Revision   int64            `json:"revision"`
+	KeyCounts  map[string]int64 `json:"key_counts"`
+	Assertions []DrillAssertion `json:"assertions"`
+}
+
+// Passed is true when every assertion passed
+func (dr *DrillResult) Passed() bool {
+	if len(dr.Assertions) == 0 {
+		return false
+	}
+
+	for _, assertion := range dr.Assertions {
+		if !assertion.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (dr *DrillResult) assert(name string, passed bool, format string, args ...interface{}) {
+	dr.Assertions = append(dr.Assertions, DrillAssertion{
+		Name:    name,
+		Passed:  passed,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+type driller struct {
+	blobClient blob.BlobClient
+	cipher     crypto.Cipher
+
+	spoolDirectory string
+	startTimeout   time.Duration
+
+	log logr.Logger
+}
+
+func NewDriller(blobClient blob.BlobClient, cipher crypto.Cipher, spoolDirectory string, log logr.Logger) *driller {
+	return &driller{
+		blobClient:     blobClient,
+		cipher:         cipher,
+		spoolDirectory: spoolDirectory,
+		startTimeout:   2 * time.Minute,
+		log:            log,
+	}
+}
+
+// Drill restores a backup into an embedded etcd on localhost and runs assertions over the restored data.
+// An error is only returned when the drill could not be run, failed assertions are reported in the result.
+func (d *driller) Drill(ctx context.Context, backupName string) (*DrillResult, error) {
+	result := &DrillResult{
+		Backup:    backupName,
+		StartedAt: time.Now().UTC(),
+		KeyCounts: map[string]int64{},
+	}
+	defer func() {
+		result.FinishedAt = time.Now().UTC()
+	}()
+
+	drillDirectory, err := os.MkdirTemp(d.spoolDirectory, "kubeadm-backup-drill-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating drill directory: %w", err)
+	}
+	defer os.RemoveAll(drillDirectory)
+
+	snapshotFile, err := os.Create(filepath.Join(drillDirectory, snapshotFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error creating drill snapshot file: %w", err)
+	}
+	defer snapshotFile.Close()
+
+	// verification runs first so the drill never starts etcd from a corrupt backup
+	verifier := NewVerifier(d.blobClient, d.cipher, d.spoolDirectory, d.log)
+	verifyResult := verifier.verify(ctx, backupName, snapshotFile)
+	result.Manifest = verifyResult.Manifest
+	if verifyResult.Err != nil {
+		result.assert("backup-verified", false, "%v", verifyResult.Err)
+		return result, nil
+	}
+	result.assert("backup-verified", true, "archive, etcd snapshot and pki files are valid")
+
+	peerURL, clientURL, err := localURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	dataDirectory := filepath.Join(drillDirectory, "etcd")
+	d.log.Info("restoring etcd snapshot for drill", "data-dir", dataDirectory)
+	err = snapshot.NewV3(zapLogger(d.log)).Restore(snapshot.RestoreConfig{
+		SnapshotPath:        snapshotFile.Name(),
+		Name:                drillMemberName,
+		OutputDataDir:       dataDirectory,
+		PeerURLs:            []string{peerURL.String()},
+		InitialCluster:      fmt.Sprintf("%s=%s", drillMemberName, peerURL.String()),
+		InitialClusterToken: "kubeadm-backup-drill",
+	})
+	if err != nil {
+		result.assert("snapshot-restored", false, "%v", err)
+		return result, nil
+	}
+	result.assert("snapshot-restored", true, "etcd snapshot restored into a new data directory")
+
+	etcdServer, err := d.startEtcd(dataDirectory, peerURL, clientURL)
+	if err != nil {
+		result.assert("etcd-started", false, "%v", err)
+		return result, nil
+	}
+	defer etcdServer.Close()
+	result.assert("etcd-started", true, "embedded etcd started on %s", clientURL.String())
+
+	etcdClient := v3client.New(etcdServer.Server)
+	defer etcdClient.Close()
+
+	if err := d.checkData(ctx, etcdClient, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (d *driller) startEtcd(dataDirectory string, peerURL, clientURL *url.URL) (*embed.Etcd, error) {
+	cfg := embed.NewConfig()
+	cfg.Name = drillMemberName
+	cfg.Dir = dataDirectory
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", drillMemberName, peerURL.String())
+	cfg.LogLevel = "error"
+	cfg.LogOutputs = []string{"stderr"}
+
+	etcdServer, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error starting embedded etcd: %w", err)
+	}
+
+	select {
+	case <-etcdServer.Server.ReadyNotify():
+		return etcdServer, nil
+	case err := <-etcdServer.Err():
+		etcdServer.Close()
+		return nil, fmt.Errorf("embedded etcd failed: %w", err)
+	case <-time.After(d.startTimeout):
+		etcdServer.Server.Stop()
+		etcdServer.Close()
+		return nil, fmt.Errorf("embedded etcd was not ready after %s", d.startTimeout)
+	}
+}
+
+func (d *driller) checkData(ctx context.Context, etcdClient *clientv3.Client, result *DrillResult) error {
+	// count keys per resource, paging through the registry so large clusters are not loaded at once
+	key := registryPrefix
+	end := clientv3.GetPrefixRangeEnd(registryPrefix)
+	for {
+		resp, err := etcdClient.Get(ctx, key, clientv3.WithRange(end), clientv3.WithKeysOnly(), clientv3.WithLimit(1000))
+		if err != nil {
+			return fmt.Errorf("error listing restored keys: %w", err)
+		}
+		result.Revision = resp.Header.Revision
+
+		for _, kv := range resp.Kvs {
+			resource := strings.SplitN(strings.TrimPrefix(string(kv.Key), registryPrefix), "/", 2)[0]
+			result.KeyCounts[resource]++
+		}
+
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+
+	var totalKeys int64
+	resources := make([]string, 0, len(result.KeyCounts))
+	for resource, count := range result.KeyCounts {
+		totalKeys += count
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	result.assert("registry-has-keys", totalKeys > 0, "%d keys in %d resources under %s", totalKeys, len(resources), registryPrefix)
+
+	for _, check := range []struct {
+		name string
+		key  string
+	}{
+		{name: "kube-system-namespace", key: kubeSystemNamespaceKey},
+		{name: "kubeadm-config-configmap", key: kubeadmConfigConfigMapKey},
+	} {
+		resp, err := etcdClient.Get(ctx, check.key, clientv3.WithCountOnly())
+		if err != nil {
+			return fmt.Errorf("error getting %s: %w", check.key, err)
+		}
+		result.assert(check.name, resp.Count == 1, "%s found: %t", check.key, resp.Count == 1)
+	}
+
+	if result.Manifest != nil {
+		// the manifest revision is read just before the snapshot so the snapshot may be slightly newer
+		result.assert("revision-matches-manifest", result.Revision >= result.Manifest.Etcd.Revision,
+			"restored revision %d, manifest revision %d", result.Revision, result.Manifest.Etcd.Revision)
+	} else {
+		result.assert("revision-matches-manifest", false, "backup has no manifest to compare the revision %d with", result.Revision)
+	}
+
+	return nil
+}
+
+// localURLs picks free localhost ports for the embedded etcd
+func localURLs() (*url.URL, *url.URL, error) {
+	var ports []int
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, fmt.Errorf("error finding a free port: %w", err)
+		}
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+		defer listener.Close()
+	}
+
+	peerURL := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", ports[0])}
+	clientURL := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", ports[1])}
+	return peerURL, clientURL, nil
+}
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// destinationQueueSize is how many archive chunks are buffered for each destination so one slow upload does not stall
+// the others on every write
+const destinationQueueSize = 64
+
+// UploadResult is the outcome of uploading a backup to a single destination
+type UploadResult struct {
+	Destination string
+	Size        int64
+	Err         error
+}
+
+// PartialBackupError is returned when a backup was uploaded to some of the destinations but not all of them
+type PartialBackupError struct {
+	Results []UploadResult
+}
+
+func (e *PartialBackupError) Error() string {
+	var failed []string
+	succeeded := 0
+	for _, result := range e.Results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", result.Destination, result.Err))
+			continue
+		}
+		succeeded++
+	}
+
+	return fmt.Sprintf("backup uploaded to %d of %d destinations, %s", succeeded, len(e.Results), strings.Join(failed, ", "))
+}
+
+// uploadError returns nil when every upload succeeded, a PartialBackupError when only some of them did and the errors
+// of every destination otherwise
+func uploadError(results []UploadResult) error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", result.Destination, result.Err))
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case len(results):
+		return errors.Join(errs...)
+	default:
+		return &PartialBackupError{Results: results}
+	}
+}
+
+// destinationWriter feeds archive chunks to the upload of one destination
+type destinationWriter struct {
+	pipeWriter *io.PipeWriter
+	chunks     chan []byte
+	// done is closed once the feeding goroutine stopped, err is set before it is closed
+	done chan struct{}
+	err  error
+}
+
+func newDestinationWriter(pipeWriter *io.PipeWriter) *destinationWriter {
+	dw := &destinationWriter{
+		pipeWriter: pipeWriter,
+		chunks:     make(chan []byte, destinationQueueSize),
+		done:       make(chan struct{}),
+	}
+
+	go func() {
+		defer close(dw.done)
+		for chunk := range dw.chunks {
+			if _, err := dw.pipeWriter.Write(chunk); err != nil {
+				dw.err = err
+				return
+			}
+		}
+	}()
+
+	return dw
+}
+
+func (dw *destinationWriter) failed() bool {
+	select {
+	case <-dw.done:
+		return dw.err != nil
+	default:
+		return false
+	}
+}
+
+// fanoutWriter writes the archive to every destination, a destination whose upload failed is skipped so the others
+// can still finish
+type fanoutWriter struct {
+	writers []*destinationWriter
+}
+
+func (fw *fanoutWriter) Write(p []byte) (int, error) {
+	// the chunk is shared by every destination and p may be reused by the caller
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+
+	live := 0
+	for _, dw := range fw.writers {
+		if dw.failed() {
+			continue
+		}
+
+		select {
+		case dw.chunks <- chunk:
+			live++
+		case <-dw.done:
+		}
+	}
+
+	if live == 0 {
+		var errs []error
+		for _, dw := range fw.writers {
+			errs = append(errs, dw.err)
+		}
+		return 0, fmt.Errorf("every destination stopped reading the backup: %w", errors.Join(errs...))
+	}
+
+	return len(p), nil
+}
+
+// Close finishes every destination, a non nil err is passed to the uploads so they are aborted
+func (fw *fanoutWriter) Close(err error) {
+	for _, dw := range fw.writers {
+		if err != nil {
+			dw.pipeWriter.CloseWithError(err)
+		}
+		close(dw.chunks)
+	}
+
+	for _, dw := range fw.writers {
+		<-dw.done
+		// closing with a nil error is the same as Close
+		dw.pipeWriter.CloseWithError(err)
+	}
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+)
+
+// clusterIdentityObjectName is the marker recording which cluster a namespace belongs to
+const clusterIdentityObjectName = "cluster-identity.json"
+
+// clusterNameRegex finds the clusterName in the ClusterConfiguration embedded in the kubeadm-config configmap. The
+// configmap is stored as protobuf so it can not be decoded without the kubernetes types, the regex runs over the raw
+// protobuf bytes instead. The ClusterConfiguration yaml is a string field that protobuf stores verbatim, so its lines
+// are intact, but the length prefix of the field comes right before the first line. kubeadm writes the keys sorted so
+// clusterName is never the first line and always starts after a newline. Control characters end the name since the
+// protobuf fields after the yaml start with one.
+var clusterNameRegex = regexp.MustCompile(`(?m)^clusterName:[ \t]*"?([^"\s\x00-\x1f]+)"?`)
+
+var (
+	// ClusterIdentityMismatch is a prometheus metric which is a Gauge of whether a destination belongs to another cluster
+	ClusterIdentityMismatch = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_cluster_identity_mismatch",
+		Help: "Whether the cluster identity marker in the destination belongs to another cluster.",
+	}, []string{"destination"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(ClusterIdentityMismatch)
+}
+
+// ClusterIdentity identifies the cluster that owns the backups in a namespace
+type ClusterIdentity struct {
+	EtcdClusterID      string `json:"etcd_cluster_id"`
+	KubeadmClusterName string `json:"kubeadm_cluster_name"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ci *ClusterIdentity) matches(other *ClusterIdentity) bool {
+	return ci.EtcdClusterID == other.EtcdClusterID && ci.KubeadmClusterName == other.KubeadmClusterName
+}
+
+// ClusterIdentityMismatchError is returned when the marker of a destination was written by another cluster
+type ClusterIdentityMismatchError struct {
+	Destination string
+	Marker      ClusterIdentity
+	Live        ClusterIdentity
+}
+
+func (e *ClusterIdentityMismatchError) Error() string {
+	return fmt.Sprintf("destination %s belongs to another cluster, its marker has etcd cluster id %s and cluster name %q but this cluster has etcd cluster id %s and cluster name %q",
+		e.Destination, e.Marker.EtcdClusterID, e.Marker.KubeadmClusterName, e.Live.EtcdClusterID, e.Live.KubeadmClusterName)
+}
+
+// liveClusterIdentity reads the identity of the cluster from etcd
+func liveClusterIdentity(ctx context.Context, etcdClient *etcd.Client, log logr.Logger) (*ClusterIdentity, error) {
+	statusCTX, statusCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer statusCancel()
+	status, err := etcdClient.Status(statusCTX)
+	if err != nil {
+		return nil, fmt.Errorf("error getting etcd status: %w", err)
+	}
+
+	getCTX, getCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer getCancel()
+	kubeadmConfig, err := etcdClient.Get(getCTX, kubeadmConfigConfigMapKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading kubeadm-config configmap: %w", err)
+	}
+
+	identity := &ClusterIdentity{
+		EtcdClusterID: fmt.Sprintf("%x", status.ClusterID),
+	}
+	match := clusterNameRegex.FindSubmatch(kubeadmConfig)
+	switch {
+	case match != nil:
+		identity.KubeadmClusterName = string(match[1])
+	case kubeadmConfig != nil:
+		// the marker written now would not record a cluster name, so a later match would look like another cluster
+		log.Info("no clusterName found in the kubeadm-config configmap, the cluster identity only contains the etcd cluster id")
+	}
+
+	return identity, nil
+}
+
+// readClusterIdentity returns the marker of a destination, nil is returned when it has none
+func readClusterIdentity(ctx context.Context, destination blob.Destination) (*ClusterIdentity, error) {
+	readCTX, readCancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer readCancel()
+
+	// blob clients have no common not found error, listing tells if the marker exists
+	it := destination.Client.List(readCTX, blob.ListOptions{Prefix: clusterIdentityObjectName})
+	found := false
+	for {
+		obj, err := it.Next()
+		if err == blob.IteratorDone {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing cluster identity marker: %w", err)
+		}
+		if obj.Key == clusterIdentityObjectName {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	reader, err := destination.Client.Read(readCTX, clusterIdentityObjectName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster identity marker: %w", err)
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	identity := &ClusterIdentity{}
+	if err := json.NewDecoder(reader).Decode(identity); err != nil {
+		return nil, fmt.Errorf("error parsing cluster identity marker: %w", err)
+	}
+
+	return identity, nil
+}
+
+func writeClusterIdentity(ctx context.Context, destination blob.Destination, identity *ClusterIdentity) error {
+	data, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cluster identity marker: %w", err)
+	}
+
+	writeCTX, writeCancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer writeCancel()
+	if err := destination.Client.Create(writeCTX, clusterIdentityObjectName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error writing cluster identity marker: %w", err)
+	}
+
+	return nil
+}
+
+// checkClusterIdentity compares the marker of a destination with the live identity and records the result in the
+// mismatch metric. A destination without a marker gets one when create is set.
+func checkClusterIdentity(ctx context.Context, destination blob.Destination, live *ClusterIdentity, create bool) (created bool, err error) {
+	marker, err := readClusterIdentity(ctx, destination)
+	if err != nil {
+		return false, err
+	}
+
+	if marker == nil {
+		ClusterIdentityMismatch.WithLabelValues(destination.Name).Set(0)
+		if !create {
+			return false, nil
+		}
+
+		marker = &ClusterIdentity{
+			EtcdClusterID:      live.EtcdClusterID,
+			KubeadmClusterName: live.KubeadmClusterName,
+			CreatedAt:          time.Now().UTC(),
+		}
+		return true, writeClusterIdentity(ctx, destination, marker)
+	}
+
+	if !marker.matches(live) {
+		ClusterIdentityMismatch.WithLabelValues(destination.Name).Set(1)
+		return false, &ClusterIdentityMismatchError{
+			Destination: destination.Name,
+			Marker:      *marker,
+			Live:        *live,
+		}
+	}
+
+	ClusterIdentityMismatch.WithLabelValues(destination.Name).Set(0)
+	return false, nil
+}
@@ -0,0 +1,49 @@
+package backup
+
+import "testing"
+
+func TestClusterNameRegex(t *testing.T) {
+	tests := []struct {
+		name          string
+		kubeadmConfig string
+		expected      string
+	}{
+		{
+			name:          "plain",
+			kubeadmConfig: "\x12\x9a\x04apiServer:\n  timeoutForControlPlane: 4m0s\napiVersion: kubeadm.k8s.io/v1beta3\nclusterName: kubernetes\ncontrolPlaneEndpoint: \"\"\n",
+			expected:      "kubernetes",
+		},
+		{
+			name:          "quoted",
+			kubeadmConfig: "\x12\x9a\x04apiServer: {}\nclusterName: \"production\"\n\x1a\x00",
+			expected:      "production",
+		},
+		{
+			name:          "last line before more protobuf fields",
+			kubeadmConfig: "\x12\x20apiServer: {}\nclusterName: prod-1\x1a\x0cClusterStatus",
+			expected:      "prod-1",
+		},
+		{
+			name:          "nested key is not the cluster name",
+			kubeadmConfig: "\x12\x20apiServer: {}\netcd:\n  clusterName: etcd\n",
+			expected:      "",
+		},
+		{
+			name:          "missing",
+			kubeadmConfig: "\x12\x20apiServer: {}\napiVersion: kubeadm.k8s.io/v1beta3\n",
+			expected:      "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clusterName := ""
+			if match := clusterNameRegex.FindStringSubmatch(test.kubeadmConfig); match != nil {
+				clusterName = match[1]
+			}
+			if clusterName != test.expected {
+				t.Errorf("found cluster name %q, expected %q", clusterName, test.expected)
+			}
+		})
+	}
+}
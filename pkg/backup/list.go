@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+)
+
+type BackupInfo struct {
+	Name string
+	// Time is when the backup was taken, it is part of the name
+	Time time.Time
+
+	Encrypted bool
+
+	Size         int64
+	LastModified time.Time
+
+	// Pin is set when the backup is pinned and never pruned
+	Pin *Pin
+}
+
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	Mode    int64
+	ModTime time.Time
+}
+
+// ListBackups returns all backups in blob storage sorted from oldest to newest
+func ListBackups(ctx context.Context, blobClient blob.BlobClient) ([]BackupInfo, error) {
+	catalog, err := LoadCatalog(ctx, blobClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog.Backups, nil
+}
+
+// ResolveBackupName returns the name of the newest backup when given latest
+func ResolveBackupName(ctx context.Context, blobClient blob.BlobClient, backupName string) (string, error) {
+	if backupName != LatestBackup {
+		return backupName, nil
+	}
+
+	backups, err := ListBackups(ctx, blobClient)
+	if err != nil {
+		return "", err
+	}
+
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backups found")
+	}
+
+	return backups[len(backups)-1].Name, nil
+}
+
+// Inspect returns the manifest and the files contained in a backup archive after validating them against the manifest.
+// The manifest is nil for backups taken before manifests existed.
+func Inspect(ctx context.Context, blobClient blob.BlobClient, cipher crypto.Cipher, backupName string) (*Manifest, []ArchiveEntry, error) {
+	archive, err := openArchive(ctx, blobClient, cipher, backupName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer archive.Close()
+
+	var entries []ArchiveEntry
+	for {
+		hdr, _, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error validating backup %s: %w", backupName, err)
+		}
+
+		entries = append(entries, ArchiveEntry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    hdr.Mode,
+			ModTime: hdr.ModTime,
+		})
+	}
+
+	return archive.Manifest(), entries, nil
+}
+
+// openArchive streams a backup from blob storage, decrypting it when it was encrypted
+func openArchive(ctx context.Context, blobClient blob.BlobClient, cipher crypto.Cipher, backupName string) (*archiveReader, error) {
+	_, encryptedSuffix, err := parseBackupObjectName(backupName)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing backup name %s: %w", backupName, err)
+	}
+
+	if encryptedSuffix != "" && (cipher == nil || cipher.Suffix() != encryptedSuffix) {
+		return nil, fmt.Errorf("backup %s is encrypted, an encryption config for %s is required", backupName, encryptedSuffix)
+	}
+
+	objectReader, err := blobClient.Read(ctx, backupName)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup %s: %w", backupName, err)
+	}
+
+	closer := archiveCloser{objectReader: objectReader}
+
+	if encryptedSuffix != "" {
+		objectReader, err = cipher.Decrypt(ctx, objectReader)
+		if err != nil {
+			closer.Close()
+			return nil, fmt.Errorf("error decrypting backup %s: %w", backupName, err)
+		}
+	}
+
+	gzipReader, err := gzip.NewReader(objectReader)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("error opening gzip stream of backup %s: %w", backupName, err)
+	}
+	closer.gzipReader = gzipReader
+
+	archive, err := newArchiveReader(tar.NewReader(gzipReader), closer)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("error opening backup %s: %w", backupName, err)
+	}
+
+	return archive, nil
+}
+
+// archiveCloser closes the gzip stream and the object reader when the blob client returned one that holds resources
+type archiveCloser struct {
+	gzipReader   *gzip.Reader
+	objectReader io.Reader
+}
+
+func (ac archiveCloser) Close() error {
+	var err error
+	if ac.gzipReader != nil {
+		err = ac.gzipReader.Close()
+	}
+
+	if closer, ok := ac.objectReader.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+const (
+	manifestFileName = "manifest.json"
+	snapshotFileName = "snapshot.db"
+
+	// manifestFormatVersion is increased when the layout of the backup archive changes
+	manifestFormatVersion = 1
+)
+
+// Manifest is the first file of every backup archive and describes the rest of it
+type Manifest struct {
+	FormatVersion        int            `json:"format_version"`
+	CreatedAt            time.Time      `json:"created_at"`
+	KubeadmBackupVersion string         `json:"kubeadm_backup_version"`
+	Hostname             string         `json:"hostname"`
+	Etcd                 ManifestEtcd   `json:"etcd"`
+	Files                []ManifestFile `json:"files"`
+}
+
+type ManifestEtcd struct {
+	Endpoint  string `json:"endpoint"`
+	MemberID  string `json:"member_id"`
+	ClusterID string `json:"cluster_id"`
+	Revision  int64  `json:"revision"`
+	Version   string `json:"version"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (m *Manifest) file(name string) (ManifestFile, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ManifestFile{}, false
+}
+
+func (m *Manifest) validate() error {
+	if m.FormatVersion < 1 || m.FormatVersion > manifestFormatVersion {
+		return fmt.Errorf("unsupported backup format version %d, this version of kubeadm-backup supports up to %d", m.FormatVersion, manifestFormatVersion)
+	}
+
+	if _, ok := m.file(snapshotFileName); !ok {
+		return fmt.Errorf("manifest does not list an etcd snapshot")
+	}
+
+	seen := map[string]bool{}
+	for _, f := range m.Files {
+		if seen[f.Name] {
+			return fmt.Errorf("manifest lists file %s more than once", f.Name)
+		}
+		seen[f.Name] = true
+
+		if _, err := hex.DecodeString(f.SHA256); err != nil || len(f.SHA256) != sha256.Size*2 {
+			return fmt.Errorf("manifest has an invalid sha256 for file %s", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// archiveReader reads a backup archive and checks every file against the manifest.
+//
+// Archives taken before the manifest existed have a nil manifest and can not be validated.
+type archiveReader struct {
+	tarReader *tar.Reader
+	closer    io.Closer
+
+	manifest *Manifest
+
+	current     *tar.Header
+	currentHash hash.Hash
+	currentSize int64
+	seen        map[string]bool
+
+	// pending is the first header when the archive has no manifest
+	pending *tar.Header
+}
+
+func newArchiveReader(tarReader *tar.Reader, closer io.Closer) (*archiveReader, error) {
+	ar := &archiveReader{
+		tarReader: tarReader,
+		closer:    closer,
+		seen:      map[string]bool{},
+	}
+
+	hdr, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("error reading first file of backup: %w", err)
+	}
+
+	if hdr.Name != manifestFileName {
+		ar.pending = hdr
+		return ar, nil
+	}
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(tarReader).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("error parsing backup manifest: %w", err)
+	}
+
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+
+	ar.manifest = manifest
+	return ar, nil
+}
+
+// Manifest returns the manifest of the archive, nil for archives without one
+func (ar *archiveReader) Manifest() *Manifest {
+	return ar.manifest
+}
+
+// Next returns the next file in the archive, reading from the archive reader hashes the file.
+// io.EOF is returned once every file was read and matched the manifest.
+func (ar *archiveReader) Next() (*tar.Header, io.Reader, error) {
+	if err := ar.finishCurrent(); err != nil {
+		return nil, nil, err
+	}
+
+	var hdr *tar.Header
+	if ar.pending != nil {
+		hdr, ar.pending = ar.pending, nil
+	} else {
+		var err error
+		hdr, err = ar.tarReader.Next()
+		if err == io.EOF {
+			return nil, nil, ar.finish()
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading tar: %w", err)
+		}
+	}
+
+	if ar.manifest != nil {
+		if _, ok := ar.manifest.file(hdr.Name); !ok {
+			return nil, nil, fmt.Errorf("file %s is not listed in the backup manifest", hdr.Name)
+		}
+		if ar.seen[hdr.Name] {
+			return nil, nil, fmt.Errorf("file %s is in the backup more than once", hdr.Name)
+		}
+	}
+
+	ar.current = hdr
+	ar.currentHash = sha256.New()
+	ar.currentSize = 0
+	return hdr, io.TeeReader(ar.tarReader, &hashCounter{hash: ar.currentHash, size: &ar.currentSize}), nil
+}
+
+func (ar *archiveReader) finishCurrent() error {
+	if ar.current == nil {
+		return nil
+	}
+	hdr := ar.current
+	ar.current = nil
+
+	// hash whatever the caller did not read
+	if _, err := io.Copy(&hashCounter{hash: ar.currentHash, size: &ar.currentSize}, ar.tarReader); err != nil {
+		return fmt.Errorf("error reading file %s of backup: %w", hdr.Name, err)
+	}
+
+	ar.seen[hdr.Name] = true
+	if ar.manifest == nil {
+		return nil
+	}
+
+	manifestFile, _ := ar.manifest.file(hdr.Name)
+	if manifestFile.Size != ar.currentSize {
+		return fmt.Errorf("file %s is %d bytes but the manifest lists %d", hdr.Name, ar.currentSize, manifestFile.Size)
+	}
+
+	sum := hex.EncodeToString(ar.currentHash.Sum(nil))
+	if sum != manifestFile.SHA256 {
+		return fmt.Errorf("file %s has sha256 %s but the manifest lists %s", hdr.Name, sum, manifestFile.SHA256)
+	}
+
+	return nil
+}
+
+func (ar *archiveReader) finish() error {
+	if ar.manifest == nil {
+		return io.EOF
+	}
+
+	for _, f := range ar.manifest.Files {
+		if !ar.seen[f.Name] {
+			return fmt.Errorf("file %s listed in the backup manifest is missing", f.Name)
+		}
+	}
+
+	return io.EOF
+}
+
+func (ar *archiveReader) Close() error {
+	return ar.closer.Close()
+}
+
+type hashCounter struct {
+	hash hash.Hash
+	size *int64
+}
+
+func (hc *hashCounter) Write(p []byte) (int, error) {
+	n, err := hc.hash.Write(p)
+	*hc.size += int64(n)
+	return n, err
+}
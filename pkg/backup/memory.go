@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const memoryMonitorInterval = 250 * time.Millisecond
+
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// memoryMonitor samples the memory held by the go runtime and remembers the peak
+type memoryMonitor struct {
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	peak uint64
+}
+
+func startMemoryMonitor() *memoryMonitor {
+	m := &memoryMonitor{
+		stopChan: make(chan struct{}),
+	}
+
+	m.sample()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(memoryMonitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stopChan:
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+
+	return m
+}
+
+func (m *memoryMonitor) sample() {
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	metrics.Read(samples)
+
+	// memory released back to the os is still counted in the total
+	inUse := samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	if inUse > m.peak {
+		m.peak = inUse
+	}
+}
+
+// Stop stops sampling and returns the peak memory in bytes
+func (m *memoryMonitor) Stop() uint64 {
+	close(m.stopChan)
+	m.wg.Wait()
+	m.sample()
+	return m.peak
+}
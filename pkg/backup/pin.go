@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+)
+
+// pinObjectSuffix is appended to the name of a backup to name the sidecar object pinning it
+const pinObjectSuffix = ".pin"
+
+// ErrBackupNotFound is returned when pinning or unpinning a backup that is not in blob storage
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Pin exempts a backup from retention until it is unpinned
+type Pin struct {
+	Reason   string    `json:"reason"`
+	PinnedAt time.Time `json:"pinned_at"`
+	PinnedBy string    `json:"pinned_by"`
+}
+
+// parsePinObjectName returns the name of the backup a pin sidecar belongs to
+func parsePinObjectName(objectName string) (string, bool) {
+	backupName, ok := strings.CutSuffix(objectName, pinObjectSuffix)
+	if !ok {
+		return "", false
+	}
+
+	if _, _, err := parseBackupObjectName(backupName); err != nil {
+		return "", false
+	}
+
+	return backupName, true
+}
+
+func readPin(ctx context.Context, blobClient blob.BlobClient, backupName string) (*Pin, error) {
+	reader, err := blobClient.Read(ctx, backupName+pinObjectSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pin of backup %s: %w", backupName, err)
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	pin := &Pin{}
+	if err := json.NewDecoder(reader).Decode(pin); err != nil {
+		return nil, fmt.Errorf("error parsing pin of backup %s: %w", backupName, err)
+	}
+
+	return pin, nil
+}
+
+// findBackup returns the backup named backupName from the catalog of blob storage, nil is returned when there is none
+func findBackup(ctx context.Context, blobClient blob.BlobClient, backupName string) (*BackupInfo, error) {
+	catalog, err := LoadCatalog(ctx, blobClient)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range catalog.Backups {
+		if catalog.Backups[i].Name == backupName {
+			return &catalog.Backups[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// PinBackup writes a pin sidecar next to a backup so it is never pruned, pinning a pinned backup replaces its reason
+func PinBackup(ctx context.Context, blobClient blob.BlobClient, backupName string, reason string) error {
+	b, err := findBackup(ctx, blobClient, backupName)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("backup %s: %w", backupName, ErrBackupNotFound)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting hostname: %w", err)
+	}
+
+	data, err := json.MarshalIndent(&Pin{
+		Reason:   reason,
+		PinnedAt: time.Now().UTC(),
+		PinnedBy: hostname,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding pin of backup %s: %w", backupName, err)
+	}
+
+	if err := blobClient.Create(ctx, backupName+pinObjectSuffix, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error writing pin of backup %s: %w", backupName, err)
+	}
+
+	return nil
+}
+
+// UnpinBackup deletes the pin sidecar of a backup so retention applies to it again
+func UnpinBackup(ctx context.Context, blobClient blob.BlobClient, backupName string) error {
+	b, err := findBackup(ctx, blobClient, backupName)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("backup %s: %w", backupName, ErrBackupNotFound)
+	}
+	if b.Pin == nil {
+		return fmt.Errorf("backup %s is not pinned", backupName)
+	}
+
+	if err := blobClient.Delete(ctx, backupName+pinObjectSuffix); err != nil {
+		return fmt.Errorf("error deleting pin of backup %s: %w", backupName, err)
+	}
+
+	return nil
+}
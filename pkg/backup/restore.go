@@ -0,0 +1,261 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.uber.org/zap"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+)
+
+const LatestBackup = "latest"
+
+type RestoreConfig struct {
+	KubeadmPKIDirectory string
+	EtcdDataDirectory   string
+
+	EtcdName                string
+	EtcdInitialCluster      string
+	EtcdInitialClusterToken string
+	EtcdPeerURLs            []string
+
+	// OverwritePKI allows existing files in the kubeadm pki directory to be replaced
+	OverwritePKI bool
+
+	// Cipher decrypts encrypted backups
+	Cipher crypto.Cipher
+}
+
+type restorePKIFile struct {
+	header *tar.Header
+	data   []byte
+}
+
+type restorer struct {
+	blobClient blob.BlobClient
+
+	config RestoreConfig
+
+	log logr.Logger
+}
+
+func NewRestorer(blobClient blob.BlobClient, config RestoreConfig, log logr.Logger) *restorer {
+	return &restorer{
+		blobClient: blobClient,
+		config:     config,
+		log:        log,
+	}
+}
+
+func (r *restorer) Restore(backupName string) error {
+	resolveCTX, resolveCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer resolveCancel()
+	backupName, err := ResolveBackupName(resolveCTX, r.blobClient, backupName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(r.config.EtcdDataDirectory); err == nil {
+		return fmt.Errorf("etcd data directory %s already exists", r.config.EtcdDataDirectory)
+	}
+
+	r.log.Info("restoring backup", "backup", backupName)
+
+	snapshotFile, err := os.CreateTemp("", "kubeadm-backup-snapshot-*.db")
+	if err != nil {
+		return fmt.Errorf("error creating temporary snapshot file: %w", err)
+	}
+	defer os.Remove(snapshotFile.Name())
+	defer snapshotFile.Close()
+
+	readCTX, readCancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer readCancel()
+	archive, err := openArchive(readCTX, r.blobClient, r.config.Cipher, backupName)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if manifest := archive.Manifest(); manifest != nil {
+		r.log.Info("backup manifest", "created-at", manifest.CreatedAt, "hostname", manifest.Hostname,
+			"etcd-cluster-id", manifest.Etcd.ClusterID, "etcd-revision", manifest.Etcd.Revision)
+	} else {
+		r.log.Info("backup has no manifest, its contents can not be validated")
+	}
+
+	// nothing is written until the whole archive has been validated
+	var pkiFiles []restorePKIFile
+	foundSnapshot := false
+	for {
+		hdr, reader, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error validating backup %s: %w", backupName, err)
+		}
+
+		switch {
+		case hdr.Name == snapshotFileName:
+			r.log.V(1).Info("extracting etcd snapshot", "size", hdr.Size)
+			if _, err := io.Copy(snapshotFile, reader); err != nil {
+				return fmt.Errorf("error extracting etcd snapshot: %w", err)
+			}
+			foundSnapshot = true
+		case strings.HasPrefix(hdr.Name, "certs/"):
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return fmt.Errorf("error reading pki file %s from backup: %w", hdr.Name, err)
+			}
+			pkiFiles = append(pkiFiles, restorePKIFile{header: hdr, data: data})
+		default:
+			r.log.Info("skipping unknown file in backup", "file", hdr.Name)
+		}
+	}
+
+	if !foundSnapshot {
+		return fmt.Errorf("backup %s does not contain an etcd snapshot", backupName)
+	}
+
+	// the pki files are checked before and only written after the etcd restore succeeded, so a failed restore
+	// never leaves the pki of the backup without its etcd data
+	for _, pkiFile := range pkiFiles {
+		if err := r.checkPKIFile(pkiFile.header); err != nil {
+			return err
+		}
+	}
+
+	if err := snapshotFile.Close(); err != nil {
+		return fmt.Errorf("error closing temporary snapshot file: %w", err)
+	}
+
+	r.log.Info("restoring etcd snapshot", "data-dir", r.config.EtcdDataDirectory)
+	err = snapshot.NewV3(zapLogger(r.log)).Restore(snapshot.RestoreConfig{
+		SnapshotPath:        snapshotFile.Name(),
+		Name:                r.config.EtcdName,
+		OutputDataDir:       r.config.EtcdDataDirectory,
+		PeerURLs:            r.config.EtcdPeerURLs,
+		InitialCluster:      r.config.EtcdInitialCluster,
+		InitialClusterToken: r.config.EtcdInitialClusterToken,
+	})
+	if err != nil {
+		return fmt.Errorf("error restoring etcd snapshot: %w", err)
+	}
+
+	if err := r.restorePKIFiles(pkiFiles); err != nil {
+		// without its pki the restored etcd data can not be used, removing it lets the restore be retried
+		if removeErr := os.RemoveAll(r.config.EtcdDataDirectory); removeErr != nil {
+			return fmt.Errorf("%w, error removing restored etcd data directory %s: %v", err, r.config.EtcdDataDirectory, removeErr)
+		}
+		return err
+	}
+
+	r.log.Info("restore done", "backup", backupName)
+	return nil
+}
+
+// pkiFilePath returns where a pki file of the backup is restored to
+func (r *restorer) pkiFilePath(hdr *tar.Header) (string, error) {
+	pkiFile := path.Clean(strings.TrimPrefix(hdr.Name, "certs/"))
+	if pkiFile == "." || strings.HasPrefix(pkiFile, "..") || path.IsAbs(pkiFile) {
+		return "", fmt.Errorf("refusing to restore pki file with invalid path %s", hdr.Name)
+	}
+
+	return filepath.Join(r.config.KubeadmPKIDirectory, filepath.FromSlash(pkiFile)), nil
+}
+
+// checkPKIFile fails when a pki file of the backup can not be restored
+func (r *restorer) checkPKIFile(hdr *tar.Header) error {
+	pkiFilePath, err := r.pkiFilePath(hdr)
+	if err != nil {
+		return err
+	}
+
+	if r.config.OverwritePKI {
+		return nil
+	}
+	if _, err := os.Lstat(pkiFilePath); err == nil {
+		return fmt.Errorf("pki file %s already exists", pkiFilePath)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking pki file %s: %w", pkiFilePath, err)
+	}
+
+	return nil
+}
+
+// restorePKIFiles writes the pki files of the backup, the files it created are removed again when writing one fails
+func (r *restorer) restorePKIFiles(pkiFiles []restorePKIFile) error {
+	var created []string
+	for _, pkiFile := range pkiFiles {
+		pkiFilePath, err := r.restorePKIFile(pkiFile.header, bytes.NewReader(pkiFile.data))
+		if pkiFilePath != "" && !r.config.OverwritePKI {
+			created = append(created, pkiFilePath)
+		}
+		if err != nil {
+			for _, createdPath := range created {
+				if removeErr := os.Remove(createdPath); removeErr != nil && !os.IsNotExist(removeErr) {
+					r.log.Error(removeErr, "error removing restored pki file", "file", createdPath)
+				}
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restorePKIFile writes a pki file of the backup, the returned path is set once the file was created
+func (r *restorer) restorePKIFile(hdr *tar.Header, reader io.Reader) (string, error) {
+	pkiFilePath, err := r.pkiFilePath(hdr)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pkiFilePath), 0755); err != nil {
+		return "", fmt.Errorf("error creating pki directory for %s: %w", pkiFilePath, err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if r.config.OverwritePKI {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+	f, err := os.OpenFile(pkiFilePath, flags, mode)
+	if err != nil {
+		return "", fmt.Errorf("error creating pki file %s: %w", pkiFilePath, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		return pkiFilePath, fmt.Errorf("error writing pki file %s: %w", pkiFilePath, err)
+	}
+
+	// the umask may have stripped bits from the mode so set it explicitly
+	if err := f.Chmod(mode); err != nil {
+		return pkiFilePath, fmt.Errorf("error setting mode of pki file %s: %w", pkiFilePath, err)
+	}
+
+	r.log.V(1).Info("restored pki file", "file", pkiFilePath, "mode", mode.String())
+	return pkiFilePath, f.Close()
+}
+
+func zapLogger(log logr.Logger) *zap.Logger {
+	if underlier, ok := log.GetSink().(zapr.Underlier); ok {
+		return underlier.GetUnderlying()
+	}
+	return zap.NewNop()
+}
@@ -2,6 +2,7 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 
 	"github.com/rmb938/kubeadm-backup/pkg/blob"
 	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
+	"github.com/rmb938/kubeadm-backup/pkg/schedule"
 )
 
 var (
@@ -27,114 +30,470 @@ var (
 		Help: "kubeadm backup success",
 	},
 	)
+	// BackupDurationSeconds is a prometheus metric which is a Gauge of how long the last backup took
+	BackupDurationSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_duration_seconds",
+		Help: "How long the last backup took in seconds.",
+	},
+	)
+	// BackupPeakMemoryBytes is a prometheus metric which is a Gauge of the peak memory used while taking the last backup
+	BackupPeakMemoryBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_peak_memory_bytes",
+		Help: "Peak memory held by the process while taking the last backup in bytes.",
+	},
+	)
+	// BackupSizeBytes is a prometheus metric which is a Gauge of the size of the last successful backup
+	BackupSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_size_bytes",
+		Help: "Size of the last successful backup archive in bytes.",
+	},
+	)
+	// BackupPartialSuccess is a prometheus metric which is a Gauge of whether the last backup only reached some destinations
+	BackupPartialSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_partial_success",
+		Help: "Whether the last backup was uploaded to some but not all destinations.",
+	},
+	)
+	// BackupDestinationSuccess is a prometheus metric which is a Gauge of whether the last upload to each destination succeeded
+	BackupDestinationSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_destination_success",
+		Help: "Whether the last backup was uploaded to the destination.",
+	}, []string{"destination"},
+	)
+	// BackupDestinationStoredBackups is a prometheus metric which is a Gauge of how many backups each destination holds
+	BackupDestinationStoredBackups = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_destination_stored_backups",
+		Help: "How many backups are stored in the destination after old backups were cleaned.",
+	}, []string{"destination"},
+	)
+	// BackupDestinationStoredBytes is a prometheus metric which is a Gauge of the size of all backups in each destination
+	BackupDestinationStoredBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_destination_stored_bytes",
+		Help: "Size of all backups stored in the destination after old backups were cleaned in bytes.",
+	}, []string{"destination"},
+	)
+	// PruneSuppressed is a prometheus metric which is a Gauge of whether the retention safety guard stopped pruning a destination
+	PruneSuppressed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_prune_suppressed",
+		Help: "Whether pruning the destination was suppressed because its newest backup is too old.",
+	}, []string{"destination"},
+	)
+	// BackupDestinationLastSuccessfulTime is a prometheus metric which is a Gauge of when each destination last received a backup
+	BackupDestinationLastSuccessfulTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_destination_last_successful_backup_time",
+		Help: "When a backup was last uploaded to the destination. Expressed as a Unix Epoch Time.",
+	}, []string{"destination"},
+	)
+)
+
+// ResultMetrics are the results of the backups, prunes and verifications this instance ran. A standby of the leader
+// election closes it so it does not report a backup that never succeeded.
+var ResultMetrics = metrics.NewGatedCollector(true,
+	BackupSuccess,
+	LastSuccessfulBackupTime,
+	BackupDurationSeconds,
+	BackupSizeBytes,
+	BackupPeakMemoryBytes,
+	BackupPartialSuccess,
+	BackupDestinationSuccess,
+	BackupDestinationLastSuccessfulTime,
+	BackupDestinationStoredBackups,
+	BackupDestinationStoredBytes,
+	PruneSuppressed,
+	VerifySuccess,
+	VerifyLastSuccessTime,
+	VerifyLastVerifiedBackupTime,
+	VerifyDurationSeconds,
 )
 
 func init() {
-	metrics.Registry.MustRegister(
-		BackupSuccess,
-		LastSuccessfulBackupTime,
-	)
+	metrics.Registry.MustRegister(ResultMetrics)
 }
 
-type backupTimer struct {
-	blobClient blob.BlobClient
-	etcdClient *etcd.Client
+type TimerConfig struct {
+	// Schedule decides when Run takes backups
+	Schedule *schedule.Scheduler
+	// Retention decides which backups are kept in destinations without their own retention
+	Retention retention.Policy
+	// MinBackups is how many of the newest backups are always kept, whatever the retention decides
+	MinBackups int
+	// MaxNewestBackupAge stops pruning a destination while its newest backup is older than it, so failing backups
+	// never lead to the last good backups being deleted. 0 disables the check.
+	MaxNewestBackupAge time.Duration
+
+	// VerifyInterval is how often the latest backup is verified, 0 disables verification
+	VerifyInterval time.Duration
+
+	// IgnoreClusterIdentity uploads and prunes even when a destination belongs to another cluster
+	IgnoreClusterIdentity bool
+}
 
-	kubeadmPKIDirectory string
+type backupTimer struct {
+	destinations []blob.Destination
+	etcdClient   *etcd.Client
 
-	interval time.Duration
-	ttl      time.Duration
+	backupConfig BackupConfig
+	config       TimerConfig
 
 	log logr.Logger
 }
 
-func NewBackupTimer(blobClient blob.BlobClient, etcdClient *etcd.Client, kubeadmPKIDirectory string, interval time.Duration, ttl time.Duration, log logr.Logger) *backupTimer {
+func NewBackupTimer(destinations []blob.Destination, etcdClient *etcd.Client, backupConfig BackupConfig, config TimerConfig, log logr.Logger) *backupTimer {
 	return &backupTimer{
-		blobClient: blobClient,
-		etcdClient: etcdClient,
-
-		kubeadmPKIDirectory: kubeadmPKIDirectory,
+		destinations: destinations,
+		etcdClient:   etcdClient,
 
-		interval: interval,
-		ttl:      ttl,
+		backupConfig: backupConfig,
+		config:       config,
 
 		log: log,
 	}
 }
 
-func (bt *backupTimer) Run() {
-	ticker := time.NewTicker(bt.interval)
+// Run takes backups on the schedule until ctx is cancelled, a backup or prune that is running when ctx is cancelled
+// is aborted
+func (bt *backupTimer) Run(ctx context.Context) {
+	if bt.config.VerifyInterval > 0 {
+		go bt.runVerify(ctx)
+	}
+
+	// destinations of another cluster are reported and set the mismatch metric at startup instead of at the first backup
+	if _, refused := bt.identifiedDestinations(ctx, false); len(refused) > 0 {
+		var names []string
+		for _, result := range refused {
+			names = append(names, result.Destination)
+		}
+		bt.log.Info("destinations failed the cluster identity check, backups are not uploaded to them until it passes", "destinations", names)
+	}
+
+	bt.config.Schedule.Resume(bt.lastBackupTime(ctx))
+
+	bt.config.Schedule.Run(ctx.Done(), func() {
+		pruneErr, backupErr := bt.RunOnce(ctx)
+		if pruneErr != nil {
+			bt.log.Error(pruneErr, "error cleaning backups")
+		}
+		if backupErr != nil {
+			bt.log.Error(backupErr, "error taking backup")
+		}
+	})
+}
+
+// lastBackupTime returns when the newest backup in any destination was taken and seeds the last successful backup
+// metrics from the backups, so a restart or a new leader neither takes a backup early nor resets the metrics
+func (bt *backupTimer) lastBackupTime(ctx context.Context) time.Time {
+	var last time.Time
+	for _, destination := range bt.destinations {
+		listCTX, listCancel := context.WithTimeout(ctx, 2*time.Minute)
+		catalog, err := LoadCatalog(listCTX, destination.Client)
+		listCancel()
+		if err != nil {
+			bt.log.Error(err, "error finding the newest backup in destination", "destination", destination.Name)
+			continue
+		}
+		if len(catalog.Backups) == 0 {
+			continue
+		}
+
+		newest := catalog.Backups[len(catalog.Backups)-1].Time
+		BackupDestinationLastSuccessfulTime.WithLabelValues(destination.Name).Set(float64(newest.Unix()))
+		if newest.After(last) {
+			last = newest
+		}
+	}
+
+	if last.IsZero() {
+		bt.log.Info("no existing backups found")
+		return last
+	}
+
+	bt.log.Info("found newest existing backup", "backup-time", last.Format(time.RFC3339Nano))
+	LastSuccessfulBackupTime.Set(float64(last.Unix()))
+	BackupSuccess.Set(1)
+	return last
+}
+
+// RunOnce does a single iteration of Run, cleaning old backups and then taking a backup
+func (bt *backupTimer) RunOnce(ctx context.Context) (pruneErr error, backupErr error) {
+	pruneErr = bt.cleanBackups(ctx, false)
+	backupErr = bt.Once(ctx)
+	return pruneErr, backupErr
+}
+
+// Once takes a single backup and records the result in the backup metrics, the backup is only successful when it was
+// uploaded to every destination
+func (bt *backupTimer) Once(ctx context.Context) error {
+	start := time.Now()
+	destinations, refused := bt.identifiedDestinations(ctx, true)
+	var results []UploadResult
+	var err error
+	if len(destinations) > 0 {
+		results, err = bt.doBackup(ctx, destinations)
+	}
+	BackupDurationSeconds.Set(time.Since(start).Seconds())
+
+	if len(refused) > 0 {
+		results = append(results, refused...)
+		var partialErr *PartialBackupError
+		if err == nil || errors.As(err, &partialErr) {
+			err = uploadError(results)
+		} else {
+			errs := []error{err}
+			for _, result := range refused {
+				errs = append(errs, fmt.Errorf("destination %s: %w", result.Destination, result.Err))
+			}
+			err = errors.Join(errs...)
+		}
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			BackupDestinationSuccess.WithLabelValues(result.Destination).Set(0)
+			continue
+		}
+		BackupDestinationSuccess.WithLabelValues(result.Destination).Set(1)
+		BackupDestinationLastSuccessfulTime.WithLabelValues(result.Destination).SetToCurrentTime()
+	}
+
+	var partialErr *PartialBackupError
+	if errors.As(err, &partialErr) {
+		BackupPartialSuccess.Set(1)
+	} else {
+		BackupPartialSuccess.Set(0)
+	}
+
+	if err != nil {
+		BackupSuccess.Set(0)
+		return err
+	}
+
+	BackupSuccess.Set(1)
+	BackupSizeBytes.Set(float64(results[0].Size))
+	LastSuccessfulBackupTime.SetToCurrentTime()
+	return nil
+}
+
+// runVerify verifies the latest backup in the first destination
+func (bt *backupTimer) runVerify(ctx context.Context) {
+	blobClient := bt.destinations[0].Client
+	verifier := NewVerifier(blobClient, bt.backupConfig.Cipher, bt.backupConfig.SpoolDirectory, bt.log.WithName("verify"))
+
+	ticker := time.NewTicker(bt.config.VerifyInterval)
 	defer ticker.Stop()
 
-	// this makes it tick once and then on interval
-	for ; true; <-ticker.C {
-		if err := bt.cleanBackups(); err != nil {
-			bt.log.Error(err, "error cleaning backups")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		if err := bt.doBackup(); err != nil {
-			bt.log.Error(err, "error taking backup")
-			BackupSuccess.Set(0)
+		verifyCTX, verifyCancel := context.WithTimeout(ctx, bt.backupConfig.Timeout)
+		backupName, err := ResolveBackupName(verifyCTX, blobClient, LatestBackup)
+		if err != nil {
+			bt.log.Error(err, "error finding backup to verify")
 		} else {
-			BackupSuccess.Set(1)
-			LastSuccessfulBackupTime.SetToCurrentTime()
+			verifier.Verify(verifyCTX, backupName)
 		}
+		verifyCancel()
 	}
 }
 
-func (bt *backupTimer) doBackup() error {
+// Prune deletes the backups the retention of their destination does not keep, when dryRun is set backups are only logged
+func (bt *backupTimer) Prune(ctx context.Context, dryRun bool) error {
+	return bt.cleanBackups(ctx, dryRun)
+}
+
+// identifiedDestinations returns the destinations that may be written to, destinations whose cluster identity marker
+// belongs to another cluster are returned as failed results unless the cluster identity is ignored. Destinations
+// without a marker get one when create is set.
+func (bt *backupTimer) identifiedDestinations(ctx context.Context, create bool) ([]blob.Destination, []UploadResult) {
+	if bt.etcdClient == nil {
+		return bt.destinations, nil
+	}
+
+	var destinations []blob.Destination
+	var refused []UploadResult
+
+	live, err := liveClusterIdentity(ctx, bt.etcdClient, bt.log)
+	if err != nil {
+		err = fmt.Errorf("error reading cluster identity: %w", err)
+		if bt.config.IgnoreClusterIdentity {
+			bt.log.Error(err, "continuing without checking the cluster identity because it is ignored")
+			return bt.destinations, nil
+		}
+		bt.log.Error(err, "refusing to write to any destination, the cluster identity could not be read")
+		for _, destination := range bt.destinations {
+			refused = append(refused, UploadResult{Destination: destination.Name, Err: err})
+		}
+		return nil, refused
+	}
+
+	for _, destination := range bt.destinations {
+		log := bt.log.WithValues("destination", destination.Name, "namespace", destination.Namespace)
+
+		created, err := checkClusterIdentity(ctx, destination, live, create)
+		if created {
+			log.Info("wrote cluster identity marker", "etcd-cluster-id", live.EtcdClusterID, "cluster-name", live.KubeadmClusterName)
+		}
+		if err != nil {
+			if bt.config.IgnoreClusterIdentity {
+				log.Error(err, "cluster identity check failed, continuing because it is ignored")
+				destinations = append(destinations, destination)
+				continue
+			}
+
+			log.Error(err, "refusing to write to destination, cluster identity check failed")
+			refused = append(refused, UploadResult{Destination: destination.Name, Err: err})
+			continue
+		}
+
+		destinations = append(destinations, destination)
+	}
+
+	return destinations, refused
+}
+
+func (bt *backupTimer) doBackup(ctx context.Context, destinations []blob.Destination) ([]UploadResult, error) {
 	bt.log.Info("taking backup")
 	b := backup{
-		blobClient:          bt.blobClient,
-		etcdClient:          bt.etcdClient,
-		kubeadmPKIDirectory: bt.kubeadmPKIDirectory,
+		destinations: destinations,
+		etcdClient:   bt.etcdClient,
+		config:       bt.backupConfig,
+	}
+	results, err := b.Take(ctx)
+	for _, result := range results {
+		if result.Err != nil {
+			bt.log.Error(result.Err, "error uploading backup to destination", "destination", result.Destination)
+			continue
+		}
+		bt.log.Info("backup uploaded to destination", "destination", result.Destination, "size", result.Size)
 	}
-	err := b.Take()
 	if err != nil {
-		return err
+		return results, err
 	}
 	bt.log.Info("backup done")
-	return nil
+	return results, nil
 }
 
-func (bt *backupTimer) cleanBackups() error {
-	bt.log.Info("cleaning old backups")
+// cleanBackups cleans every destination even when cleaning one of them fails
+func (bt *backupTimer) cleanBackups(ctx context.Context, dryRun bool) error {
+	destinations, refused := bt.identifiedDestinations(ctx, false)
+
+	var errs []error
+	for _, result := range refused {
+		errs = append(errs, fmt.Errorf("not cleaning destination %s: %w", result.Destination, result.Err))
+	}
+	for _, destination := range destinations {
+		if err := bt.cleanDestination(ctx, destination, dryRun); err != nil {
+			errs = append(errs, fmt.Errorf("error cleaning destination %s: %w", destination.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	listCTX, listCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+func (bt *backupTimer) cleanDestination(ctx context.Context, destination blob.Destination, dryRun bool) error {
+	policy := bt.config.Retention
+	if destination.Retention != nil {
+		policy = *destination.Retention
+	}
+
+	log := bt.log.WithValues("destination", destination.Name, "namespace", destination.Namespace)
+	log.Info("cleaning old backups", "retention", policy.String())
+
+	listCTX, listCancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer listCancel()
-	objectNamesChan := bt.blobClient.List(listCTX)
-
-	for objInterface := range objectNamesChan {
-		switch objInterface.(type) {
-		case error:
-			return objInterface.(error)
-		case string:
-			objectName := objInterface.(string)
-
-			objectTime, err := time.Parse(time.RFC3339Nano, objectName[7:len(objectName)-7])
-			if err != nil {
-				return fmt.Errorf("error parsing backup time for object %s: %w", objectName, err)
-			}
+	catalog, err := LoadCatalog(listCTX, destination.Client)
+	if err != nil {
+		return err
+	}
 
-			now := time.Now()
+	ForeignObjects.WithLabelValues(destination.Name).Set(float64(len(catalog.ForeignObjects)))
+	for _, objectName := range catalog.ForeignObjects {
+		log.V(1).Info("skipping object that is not a backup", "object", objectName)
+	}
 
-			if now.After(objectTime.Add(bt.ttl)) {
-				bt.log.Info("Deleting old backup", "backup-time", objectTime.Format(time.RFC3339Nano))
+	backups := make([]retention.Backup, len(catalog.Backups))
+	for i, b := range catalog.Backups {
+		backups[i] = retention.Backup{Time: b.Time}
+		if b.Pin != nil {
+			backups[i].Pinned = true
+			backups[i].PinReason = b.Pin.Reason
+		}
+	}
+	now := time.Now()
+	decisions := policy.Apply(backups, now)
+	bt.guardDecisions(log, destination, catalog.Backups, decisions, now)
 
-				deleteCTX, deleteCancel := context.WithTimeout(context.Background(), 2*time.Minute)
-				defer deleteCancel()
-				err = bt.blobClient.Delete(deleteCTX, objectName)
-				if err != nil {
-					return fmt.Errorf("error deleting old backup taken at %v: %w", objectTime.Format(time.RFC3339Nano), err)
-				}
+	var errs []error
+	storedBackups := 0
+	var storedBytes int64
+	for i, b := range catalog.Backups {
+		decision := decisions[i]
+		if decision.Keep {
+			log.V(1).Info("keeping backup", "backup", b.Name, "reasons", decision.Reasons)
+			storedBackups++
+			storedBytes += b.Size
+			continue
+		}
 
-				bt.log.Info("Deleted old backup", "backup-time", objectTime.Format(time.RFC3339Nano))
-			}
-		default:
-			return fmt.Errorf("Unknown type from objects channel: %T", objInterface)
+		if dryRun {
+			log.Info("Would delete old backup", "backup", b.Name, "backup-time", b.Time.Format(time.RFC3339Nano), "size", b.Size, "reasons", decision.Reasons)
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped cleaning old backups: %w", err)
+		}
+
+		log.Info("Deleting old backup", "backup-time", b.Time.Format(time.RFC3339Nano), "reasons", decision.Reasons)
+
+		deleteCTX, deleteCancel := context.WithTimeout(ctx, 2*time.Minute)
+		err = destination.Client.Delete(deleteCTX, b.Name)
+		deleteCancel()
+		if err != nil {
+			// the backup is still stored, the others can still be deleted
+			errs = append(errs, fmt.Errorf("error deleting old backup taken at %v: %w", b.Time.Format(time.RFC3339Nano), err))
+			storedBackups++
+			storedBytes += b.Size
+			continue
 		}
+
+		log.Info("Deleted old backup", "backup-time", b.Time.Format(time.RFC3339Nano))
 	}
 
-	bt.log.Info("done cleaning old backups")
-	return nil
+	if !dryRun {
+		BackupDestinationStoredBackups.WithLabelValues(destination.Name).Set(float64(storedBackups))
+		BackupDestinationStoredBytes.WithLabelValues(destination.Name).Set(float64(storedBytes))
+	}
+
+	log.Info("done cleaning old backups", "kept", storedBackups, "foreign-objects", len(catalog.ForeignObjects))
+	return errors.Join(errs...)
+}
+
+// guardDecisions keeps the newest MinBackups backups and keeps every backup when the newest backup is older than
+// MaxNewestBackupAge, backups must be sorted from oldest to newest
+func (bt *backupTimer) guardDecisions(log logr.Logger, destination blob.Destination, backups []BackupInfo, decisions []retention.Decision, now time.Time) {
+	PruneSuppressed.WithLabelValues(destination.Name).Set(0)
+	if len(backups) == 0 {
+		return
+	}
+
+	newest := backups[len(backups)-1]
+	if bt.config.MaxNewestBackupAge > 0 && now.Sub(newest.Time) > bt.config.MaxNewestBackupAge {
+		PruneSuppressed.WithLabelValues(destination.Name).Set(1)
+		log.Error(fmt.Errorf("newest backup was taken at %s, more than %s ago", newest.Time.Format(time.RFC3339Nano), bt.config.MaxNewestBackupAge),
+			"not pruning destination, backups may be failing")
+		for i := range decisions {
+			decisions[i] = retention.Decision{Keep: true, Reasons: []string{"pruning suppressed, newest backup is too old"}}
+		}
+		return
+	}
+
+	for i := len(decisions) - 1; i >= 0 && i >= len(decisions)-bt.config.MinBackups; i-- {
+		if !decisions[i].Keep {
+			decisions[i] = retention.Decision{Keep: true}
+		}
+		decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("min_backups %d of %d", len(decisions)-i, bt.config.MinBackups))
+	}
 }
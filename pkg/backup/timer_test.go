@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
+)
+
+func TestGuardDecisions(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name               string
+		minBackups         int
+		maxNewestBackupAge time.Duration
+		policy             retention.Policy
+		// ages are how long before now the backups were taken, from oldest to newest
+		ages []time.Duration
+		// pinned are the indexes of the pinned backups
+		pinned []int
+
+		kept       []int
+		suppressed bool
+		// reasons are the expected reasons of some of the backups
+		reasons map[int][]string
+	}{
+		{
+			name:       "no backups",
+			minBackups: 3,
+			policy:     retention.Policy{KeepLast: 1},
+			ages:       nil,
+			kept:       nil,
+		},
+		{
+			name:       "exactly min backups are all kept",
+			minBackups: 3,
+			policy:     retention.Policy{KeepWithin: time.Hour},
+			ages:       []time.Duration{72 * time.Hour, 48 * time.Hour, 24 * time.Hour},
+			kept:       []int{0, 1, 2},
+			reasons: map[int][]string{
+				0: {"min_backups 3 of 3"},
+				2: {"min_backups 1 of 3"},
+			},
+		},
+		{
+			name:       "fewer than min backups are all kept",
+			minBackups: 3,
+			policy:     retention.Policy{KeepWithin: time.Hour},
+			ages:       []time.Duration{48 * time.Hour, 24 * time.Hour},
+			kept:       []int{0, 1},
+		},
+		{
+			name:       "backups older than the newest min backups are pruned",
+			minBackups: 2,
+			policy:     retention.Policy{KeepWithin: time.Hour},
+			ages:       []time.Duration{96 * time.Hour, 72 * time.Hour, 48 * time.Hour, 24 * time.Hour},
+			kept:       []int{2, 3},
+			reasons: map[int][]string{
+				1: {"not kept by any rule"},
+			},
+		},
+		{
+			name:       "min backups adds to the reasons of kept backups",
+			minBackups: 1,
+			policy:     retention.Policy{KeepLast: 1},
+			ages:       []time.Duration{48 * time.Hour, 24 * time.Hour},
+			kept:       []int{1},
+			reasons: map[int][]string{
+				1: {"keep_last 1 of 1", "min_backups 1 of 1"},
+			},
+		},
+		{
+			name:               "newest backup too old keeps everything",
+			minBackups:         1,
+			maxNewestBackupAge: 48 * time.Hour,
+			policy:             retention.Policy{KeepLast: 1},
+			ages:               []time.Duration{96 * time.Hour, 72 * time.Hour, 49 * time.Hour},
+			kept:               []int{0, 1, 2},
+			suppressed:         true,
+			reasons: map[int][]string{
+				0: {"pruning suppressed, newest backup is too old"},
+				2: {"pruning suppressed, newest backup is too old"},
+			},
+		},
+		{
+			name:               "newest backup exactly max age old is not too old",
+			minBackups:         1,
+			maxNewestBackupAge: 48 * time.Hour,
+			policy:             retention.Policy{KeepLast: 1},
+			ages:               []time.Duration{72 * time.Hour, 48 * time.Hour},
+			kept:               []int{1},
+		},
+		{
+			name:       "max newest backup age of 0 disables the check",
+			minBackups: 1,
+			policy:     retention.Policy{KeepLast: 1},
+			ages:       []time.Duration{720 * time.Hour, 480 * time.Hour},
+			kept:       []int{1},
+		},
+		{
+			name:       "pinned backups are kept outside of min backups",
+			minBackups: 1,
+			policy:     retention.Policy{KeepWithin: time.Hour},
+			ages:       []time.Duration{96 * time.Hour, 72 * time.Hour, 48 * time.Hour, 24 * time.Hour},
+			pinned:     []int{0},
+			kept:       []int{0, 3},
+			reasons: map[int][]string{
+				0: {"pinned: test"},
+			},
+		},
+		{
+			name:       "pinned backups count towards min backups",
+			minBackups: 2,
+			policy:     retention.Policy{KeepWithin: time.Hour},
+			ages:       []time.Duration{72 * time.Hour, 48 * time.Hour, 24 * time.Hour},
+			pinned:     []int{2},
+			kept:       []int{1, 2},
+			reasons: map[int][]string{
+				1: {"min_backups 2 of 2"},
+				2: {"pinned: test", "min_backups 1 of 2"},
+			},
+		},
+		{
+			name:               "pinned backups do not stop pruning from being suppressed",
+			minBackups:         1,
+			maxNewestBackupAge: 24 * time.Hour,
+			policy:             retention.Policy{KeepLast: 1},
+			ages:               []time.Duration{72 * time.Hour, 48 * time.Hour},
+			pinned:             []int{1},
+			kept:               []int{0, 1},
+			suppressed:         true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destination := blob.Destination{Name: fmt.Sprintf("guard-%d", i)}
+			bt := &backupTimer{
+				config: TimerConfig{
+					MinBackups:         test.minBackups,
+					MaxNewestBackupAge: test.maxNewestBackupAge,
+				},
+			}
+
+			backups := make([]BackupInfo, len(test.ages))
+			retentionBackups := make([]retention.Backup, len(test.ages))
+			for i, age := range test.ages {
+				backups[i] = BackupInfo{Name: fmt.Sprintf("backup-%d", i), Time: now.Add(-age)}
+				retentionBackups[i] = retention.Backup{Time: backups[i].Time}
+			}
+			for _, i := range test.pinned {
+				backups[i].Pin = &Pin{Reason: "test"}
+				retentionBackups[i].Pinned = true
+				retentionBackups[i].PinReason = "test"
+			}
+
+			decisions := test.policy.Apply(retentionBackups, now)
+			bt.guardDecisions(logr.Discard(), destination, backups, decisions, now)
+
+			var kept []int
+			for i, decision := range decisions {
+				if decision.Keep {
+					kept = append(kept, i)
+				}
+			}
+			if !reflect.DeepEqual(kept, test.kept) {
+				t.Errorf("kept backups %v, expected %v", kept, test.kept)
+				for i, decision := range decisions {
+					t.Logf("backup %d: %v", i, decision.Reasons)
+				}
+			}
+
+			for i, reasons := range test.reasons {
+				if !reflect.DeepEqual(decisions[i].Reasons, reasons) {
+					t.Errorf("backup %d has reasons %q, expected %q", i, decisions[i].Reasons, reasons)
+				}
+			}
+
+			suppressed := testutil.ToFloat64(PruneSuppressed.WithLabelValues(destination.Name)) == 1
+			if suppressed != test.suppressed {
+				t.Errorf("prune suppressed is %t, expected %t", suppressed, test.suppressed)
+			}
+		})
+	}
+}
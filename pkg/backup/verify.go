@@ -0,0 +1,275 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+)
+
+var (
+	// VerifySuccess is a prometheus metric which is a Gauge of if the last verified backup was valid
+	VerifySuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_verify_success",
+		Help: "If the last verified backup could be restored.",
+	},
+	)
+	VerifyLastSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_verify_last_success_time",
+		Help: "When a backup was last successfully verified. Expressed as a Unix Epoch Time.",
+	},
+	)
+	VerifyLastVerifiedBackupTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_verify_last_verified_backup_time",
+		Help: "When the last successfully verified backup was taken. Expressed as a Unix Epoch Time.",
+	},
+	)
+	VerifyDurationSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_verify_duration_seconds",
+		Help: "How long the last backup verification took in seconds.",
+	},
+	)
+	VerifyFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kubeadm_backup_verify_failures_total",
+		Help: "Number of backups that failed verification.",
+	},
+	)
+)
+
+func init() {
+	// the gauges are registered as part of ResultMetrics
+	metrics.Registry.MustRegister(VerifyFailuresTotal)
+}
+
+// VerifyResult is the outcome of verifying a single backup
+type VerifyResult struct {
+	Backup   string
+	Manifest *Manifest
+	Snapshot *snapshot.Status
+	Err      error
+}
+
+type verifier struct {
+	blobClient blob.BlobClient
+	cipher     crypto.Cipher
+
+	spoolDirectory string
+
+	log logr.Logger
+}
+
+func NewVerifier(blobClient blob.BlobClient, cipher crypto.Cipher, spoolDirectory string, log logr.Logger) *verifier {
+	return &verifier{
+		blobClient:     blobClient,
+		cipher:         cipher,
+		spoolDirectory: spoolDirectory,
+		log:            log,
+	}
+}
+
+// Verify downloads a backup and checks that it can be restored, the result is recorded in the verify metrics
+func (v *verifier) Verify(ctx context.Context, backupName string) VerifyResult {
+	start := time.Now()
+	result := v.verifyToTempFile(ctx, backupName)
+	VerifyDurationSeconds.Set(time.Since(start).Seconds())
+
+	if result.Err != nil {
+		v.log.Error(result.Err, "backup failed verification", "backup", backupName)
+		VerifySuccess.Set(0)
+		VerifyFailuresTotal.Inc()
+		return result
+	}
+
+	v.log.Info("backup verified", "backup", backupName)
+	VerifySuccess.Set(1)
+	VerifyLastSuccessTime.SetToCurrentTime()
+	if backupTime, _, err := parseBackupObjectName(backupName); err == nil {
+		VerifyLastVerifiedBackupTime.Set(float64(backupTime.Unix()))
+	}
+	return result
+}
+
+func (v *verifier) verifyToTempFile(ctx context.Context, backupName string) VerifyResult {
+	snapshotFile, err := os.CreateTemp(v.spoolDirectory, "kubeadm-backup-verify-*.db")
+	if err != nil {
+		return VerifyResult{Backup: backupName, Err: fmt.Errorf("error creating temporary snapshot file: %w", err)}
+	}
+	defer os.Remove(snapshotFile.Name())
+	defer snapshotFile.Close()
+
+	return v.verify(ctx, backupName, snapshotFile)
+}
+
+// verify checks a backup, the etcd snapshot is extracted into snapshotFile
+func (v *verifier) verify(ctx context.Context, backupName string, snapshotFile *os.File) VerifyResult {
+	result := VerifyResult{Backup: backupName}
+
+	v.log.Info("verifying backup", "backup", backupName)
+
+	archive, err := openArchive(ctx, v.blobClient, v.cipher, backupName)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer archive.Close()
+	result.Manifest = archive.Manifest()
+
+	foundSnapshot := false
+	foundPKIFiles := map[string]bool{}
+	for {
+		hdr, reader, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Err = fmt.Errorf("error validating backup archive: %w", err)
+			return result
+		}
+
+		switch {
+		case hdr.Name == snapshotFileName:
+			if _, err := io.Copy(snapshotFile, reader); err != nil {
+				result.Err = fmt.Errorf("error extracting etcd snapshot: %w", err)
+				return result
+			}
+			foundSnapshot = true
+		case strings.HasPrefix(hdr.Name, "certs/"):
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				result.Err = fmt.Errorf("error reading pki file %s: %w", hdr.Name, err)
+				return result
+			}
+			if err := verifyPEM(data); err != nil {
+				result.Err = fmt.Errorf("pki file %s is invalid: %w", hdr.Name, err)
+				return result
+			}
+			foundPKIFiles[strings.TrimPrefix(hdr.Name, "certs/")] = true
+		}
+	}
+
+	if !foundSnapshot {
+		result.Err = fmt.Errorf("backup does not contain an etcd snapshot")
+		return result
+	}
+
+	for _, pkiFile := range pkiFiles {
+		if !foundPKIFiles[pkiFile] {
+			result.Err = fmt.Errorf("backup does not contain pki file %s", pkiFile)
+			return result
+		}
+	}
+
+	if err := snapshotFile.Close(); err != nil {
+		result.Err = fmt.Errorf("error closing temporary snapshot file: %w", err)
+		return result
+	}
+
+	if err := verifySnapshotHash(snapshotFile.Name()); err != nil {
+		result.Err = err
+		return result
+	}
+
+	status, err := snapshot.NewV3(zapLogger(v.log)).Status(snapshotFile.Name())
+	if err != nil {
+		result.Err = fmt.Errorf("etcd snapshot failed status check: %w", err)
+		return result
+	}
+	result.Snapshot = &status
+
+	v.log.V(1).Info("etcd snapshot status", "backup", backupName, "revision", status.Revision, "total-keys", status.TotalKey, "total-size", status.TotalSize)
+	return result
+}
+
+// verifySnapshotHash checks the sha256 etcd appends to snapshots, the same check etcd does before restoring
+func verifySnapshotHash(snapshotPath string) error {
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("error opening etcd snapshot: %w", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error stat etcd snapshot: %w", err)
+	}
+
+	// bolt databases are page aligned so the hash is whatever is left over
+	size := stat.Size()
+	if size%512 != sha256.Size {
+		return fmt.Errorf("etcd snapshot does not end with a sha256 hash")
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size-sha256.Size); err != nil {
+		return fmt.Errorf("error hashing etcd snapshot: %w", err)
+	}
+
+	expected := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(f, expected); err != nil {
+		return fmt.Errorf("error reading etcd snapshot hash: %w", err)
+	}
+
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return fmt.Errorf("etcd snapshot hash does not match its contents")
+	}
+
+	return nil
+}
+
+// verifyPEM checks that data only contains parsable certificates and keys
+func verifyPEM(data []byte) error {
+	blocks := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks++
+
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			_, err = x509.ParseCertificate(block.Bytes)
+		case "RSA PRIVATE KEY":
+			_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			_, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			_, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			_, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			err = fmt.Errorf("unexpected pem block type %s", block.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", block.Type, err)
+		}
+	}
+
+	if blocks == 0 {
+		return fmt.Errorf("no pem data found")
+	}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		return fmt.Errorf("unexpected data after pem blocks")
+	}
+
+	return nil
+}
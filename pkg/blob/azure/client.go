@@ -0,0 +1,232 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
+)
+
+type blobStorageConfig struct {
+	StorageAccount string `yaml:"storage_account"`
+	Container      string `yaml:"container"`
+	// Endpoint is the blob service url, defaults to https://<storage_account>.blob.core.windows.net/
+	Endpoint string `yaml:"endpoint"`
+
+	StorageAccountKey       string `yaml:"storage_account_key"`
+	SASToken                string `yaml:"sas_token"`
+	ManagedIdentityClientID string `yaml:"managed_identity_client_id"`
+
+	BlockSize   int64 `yaml:"block_size"`
+	Concurrency int   `yaml:"concurrency"`
+}
+
+type blobClient struct {
+	config      *blobStorageConfig
+	azureClient *azblob.Client
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{
+		// the azure client buffers a whole block in memory for every concurrent upload
+		BlockSize:   16 * 1024 * 1024,
+		Concurrency: 1,
+	}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing azure blob storage config: %w", err)
+	}
+
+	if config.Container == "" {
+		return nil, fmt.Errorf("missing azure container name in blob storage config")
+	}
+
+	serviceURL := config.Endpoint
+	if serviceURL == "" {
+		if config.StorageAccount == "" {
+			return nil, fmt.Errorf("missing azure storage account or endpoint in blob storage config")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", config.StorageAccount)
+	}
+
+	options := &azblob.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Telemetry: policy.TelemetryOptions{
+				ApplicationID: fmt.Sprintf("kubeadm-backup/%s", version.Version),
+			},
+		},
+	}
+
+	var azureClient *azblob.Client
+	switch {
+	case config.StorageAccountKey != "":
+		if config.StorageAccount == "" {
+			return nil, fmt.Errorf("missing azure storage account for storage account key in blob storage config")
+		}
+
+		credential, err := azblob.NewSharedKeyCredential(config.StorageAccount, config.StorageAccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("error creating azure shared key credential: %w", err)
+		}
+
+		azureClient, err = azblob.NewClientWithSharedKeyCredential(serviceURL, credential, options)
+		if err != nil {
+			return nil, fmt.Errorf("error creating azure client: %w", err)
+		}
+	case config.SASToken != "":
+		azureClient, err = azblob.NewClientWithNoCredential(withSASToken(serviceURL, config.SASToken), options)
+		if err != nil {
+			return nil, fmt.Errorf("error creating azure client: %w", err)
+		}
+	default:
+		credential, err := tokenCredential(config)
+		if err != nil {
+			return nil, err
+		}
+
+		azureClient, err = azblob.NewClient(serviceURL, credential, options)
+		if err != nil {
+			return nil, fmt.Errorf("error creating azure client: %w", err)
+		}
+	}
+
+	return &blobClient{
+		config:      config,
+		azureClient: azureClient,
+	}, nil
+}
+
+// tokenCredential uses workload identity when the pod has a federated token and falls back to the managed identity of the VM
+func tokenCredential(config *blobStorageConfig) (azcore.TokenCredential, error) {
+	if os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "" {
+		credential, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID: config.ManagedIdentityClientID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating azure workload identity credential: %w", err)
+		}
+		return credential, nil
+	}
+
+	managedIdentityOptions := &azidentity.ManagedIdentityCredentialOptions{}
+	if config.ManagedIdentityClientID != "" {
+		managedIdentityOptions.ID = azidentity.ClientID(config.ManagedIdentityClientID)
+	}
+
+	credential, err := azidentity.NewManagedIdentityCredential(managedIdentityOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure managed identity credential: %w", err)
+	}
+	return credential, nil
+}
+
+func withSASToken(serviceURL, sasToken string) string {
+	sasToken = strings.TrimPrefix(sasToken, "?")
+	if strings.Contains(serviceURL, "?") {
+		return serviceURL + "&" + sasToken
+	}
+	return serviceURL + "?" + sasToken
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	_, err := bc.azureClient.UploadStream(ctx, bc.config.Container, objectName, reader, &azblob.UploadStreamOptions{
+		BlockSize:   bc.config.BlockSize,
+		Concurrency: bc.config.Concurrency,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing object %s to container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	return nil
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	resp, err := bc.azureClient.DownloadStream(ctx, bc.config.Container, objectName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s from container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	// the retry reader resumes the download from where it left off when the connection breaks
+	return resp.NewRetryReader(ctx, nil), nil
+}
+
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	listOptions := &azblob.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Metadata: true},
+	}
+	if opts.Prefix != "" {
+		listOptions.Prefix = &opts.Prefix
+	}
+	if opts.PageSize > 0 {
+		pageSize := int32(opts.PageSize)
+		listOptions.MaxResults = &pageSize
+	}
+	pager := bc.azureClient.NewListBlobsFlatPager(bc.config.Container, listOptions)
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		if !pager.More() {
+			return nil, true, nil
+		}
+
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("error listing container %s: %w", bc.config.Container, err)
+		}
+
+		var objects []object.Info
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+
+			info := object.Info{
+				Key:      *item.Name,
+				Metadata: map[string]string{},
+			}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					info.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					info.LastModified = *item.Properties.LastModified
+				}
+				if item.Properties.ETag != nil {
+					info.ETag = strings.Trim(string(*item.Properties.ETag), "\"")
+				}
+			}
+			for key, value := range item.Metadata {
+				if value != nil {
+					info.Metadata[key] = *value
+				}
+			}
+
+			objects = append(objects, info)
+		}
+
+		return objects, !pager.More(), nil
+	})
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	_, err := bc.azureClient.DeleteBlob(ctx, bc.config.Container, objectName, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting object %s: %w", objectName, err)
+	}
+
+	return nil
+}
+
+func (bc *blobClient) Close() error {
+	return nil
+}
@@ -3,12 +3,23 @@ package blob
 import (
 	"context"
 	"io"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
 )
 
+type ObjectInfo = object.Info
+
+type ListOptions = object.ListOptions
+
+type ObjectIterator = object.Iterator
+
+// IteratorDone is returned by ObjectIterator.Next once every object has been returned
+var IteratorDone = object.Done
+
 type BlobClient interface {
 	Create(ctx context.Context, objectName string, reader io.Reader) error
 	Read(ctx context.Context, objectName string) (io.Reader, error)
-	List(ctx context.Context) <-chan interface{}
+	List(ctx context.Context, opts ListOptions) ObjectIterator
 	Delete(ctx context.Context, objectName string) error
 	Close() error
 }
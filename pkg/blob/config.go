@@ -5,26 +5,85 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
 
+	"github.com/rmb938/kubeadm-backup/pkg/blob/azure"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/exec"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/fs"
 	"github.com/rmb938/kubeadm-backup/pkg/blob/gcs"
 	"github.com/rmb938/kubeadm-backup/pkg/blob/s3"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/sftp"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/swift"
+	"github.com/rmb938/kubeadm-backup/pkg/blob/webdav"
+	"github.com/rmb938/kubeadm-backup/pkg/retention"
 )
 
 type BlobStorageType string
 
 const (
-	GCS BlobStorageType = "GCS"
-	S3  BlobStorageType = "S3"
+	GCS        BlobStorageType = "GCS"
+	S3         BlobStorageType = "S3"
+	AZURE      BlobStorageType = "AZURE"
+	FILESYSTEM BlobStorageType = "FILESYSTEM"
+	SFTP       BlobStorageType = "SFTP"
+	SWIFT      BlobStorageType = "SWIFT"
+	WEBDAV     BlobStorageType = "WEBDAV"
+	EXEC       BlobStorageType = "EXEC"
 )
 
+// DefaultDestinationName is the name of the destination of a config without destinations
+const DefaultDestinationName = "default"
+
 type BlobStorageConfig struct {
 	Type   BlobStorageType `yaml:"type"`
 	Config interface{}     `yaml:"config"`
+
+	// Prefix is prepended to the name of every object, destinations without their own prefix use it as well
+	Prefix string `yaml:"prefix"`
+	// Retention is used by destinations without their own retention, the backup-ttl flag is used when it is not set
+	Retention *retention.Policy `yaml:"retention"`
+
+	// Destinations are uploaded to in parallel, it can not be combined with Type
+	Destinations []DestinationConfig `yaml:"destinations"`
+}
+
+type DestinationConfig struct {
+	Name string `yaml:"name"`
+	// TTL is how long backups are kept in this destination, it is the same as a retention with only keep_within
+	TTL       time.Duration     `yaml:"ttl"`
+	Retention *retention.Policy `yaml:"retention"`
+	// Prefix overrides the prefix of the blob storage config
+	Prefix string `yaml:"prefix"`
+
+	Type   BlobStorageType `yaml:"type"`
+	Config interface{}     `yaml:"config"`
+}
+
+// backendConfigNodes are the backend configs of a blob storage config as they were written, decoding them into
+// interface{} would turn an unquoted mode like 0640 into the decimal number 416
+type backendConfigNodes struct {
+	Config       yaml3.Node `yaml:"config"`
+	Destinations []struct {
+		Config yaml3.Node `yaml:"config"`
+	} `yaml:"destinations"`
+}
+
+// Destination is a blob storage backups are uploaded to
+type Destination struct {
+	Name string
+	// Retention decides which backups are kept, nil uses the retention of the backup timer
+	Retention *retention.Policy
+	// Namespace is where the backups of the cluster are stored in the blob storage, <prefix>/<cluster>/
+	Namespace string
+	Client    BlobClient
 }
 
-func CreateBlobClientFromConfig(configFilePath string) (BlobClient, error) {
+// CreateDestinationsFromConfig creates the destinations of the config, the clients only see the objects in the
+// namespace of the cluster when a prefix or clusterName is set
+func CreateDestinationsFromConfig(configFilePath string, clusterName string) ([]Destination, error) {
 	blobStorageConfig := &BlobStorageConfig{}
 
 	rawConfig, err := os.ReadFile(configFilePath)
@@ -37,23 +96,157 @@ func CreateBlobClientFromConfig(configFilePath string) (BlobClient, error) {
 		return nil, fmt.Errorf("error unmarshaling blob storage config: %w", err)
 	}
 
-	config, err := yaml.Marshal(blobStorageConfig.Config)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling content of blob storage config: %w", err)
+	configNodes := &backendConfigNodes{}
+	if err := yaml3.Unmarshal(rawConfig, configNodes); err != nil {
+		return nil, fmt.Errorf("error unmarshaling blob storage config: %w", err)
+	}
+
+	destinationConfigs := blobStorageConfig.Destinations
+	var destinationConfigNodes []*yaml3.Node
+	for i := range configNodes.Destinations {
+		destinationConfigNodes = append(destinationConfigNodes, &configNodes.Destinations[i].Config)
+	}
+	switch {
+	case len(destinationConfigs) > 0 && blobStorageConfig.Type != "":
+		return nil, fmt.Errorf("blob storage config can not have both a type and destinations")
+	case len(destinationConfigs) == 0:
+		destinationConfigs = []DestinationConfig{
+			{
+				Name:   DefaultDestinationName,
+				Type:   blobStorageConfig.Type,
+				Config: blobStorageConfig.Config,
+			},
+		}
+		destinationConfigNodes = []*yaml3.Node{&configNodes.Config}
+	}
+
+	var destinations []Destination
+	names := map[string]bool{}
+	for i, destinationConfig := range destinationConfigs {
+		if destinationConfig.Name == "" {
+			closeDestinations(destinations)
+			return nil, fmt.Errorf("blob storage destination with type %s has no name", destinationConfig.Type)
+		}
+		if names[destinationConfig.Name] {
+			closeDestinations(destinations)
+			return nil, fmt.Errorf("blob storage destination %s is configured more than once", destinationConfig.Name)
+		}
+		names[destinationConfig.Name] = true
+
+		policy, err := destinationRetention(blobStorageConfig, destinationConfig)
+		if err != nil {
+			closeDestinations(destinations)
+			return nil, fmt.Errorf("error creating blob storage destination %s: %w", destinationConfig.Name, err)
+		}
+
+		prefix := destinationConfig.Prefix
+		if prefix == "" {
+			prefix = blobStorageConfig.Prefix
+		}
+
+		namespace, err := Namespace(prefix, clusterName)
+		if err != nil {
+			closeDestinations(destinations)
+			return nil, fmt.Errorf("error creating blob storage destination %s: %w", destinationConfig.Name, err)
+		}
+
+		client, err := createBlobClient(destinationConfig.Type, destinationConfigNodes[i])
+		if err != nil {
+			closeDestinations(destinations)
+			return nil, fmt.Errorf("error creating blob storage destination %s: %w", destinationConfig.Name, err)
+		}
+
+		// the root namespace is wrapped as well so objects in the namespaces of other clusters are not listed
+		client = NewNamespacedClient(client, namespace)
+
+		destinations = append(destinations, Destination{
+			Name:      destinationConfig.Name,
+			Retention: policy,
+			Namespace: namespace,
+			Client:    client,
+		})
+	}
+
+	return destinations, nil
+}
+
+// destinationRetention returns the retention of a destination, nil when neither the destination nor the config has one
+func destinationRetention(blobStorageConfig *BlobStorageConfig, destinationConfig DestinationConfig) (*retention.Policy, error) {
+	policy := blobStorageConfig.Retention
+	switch {
+	case destinationConfig.Retention != nil && destinationConfig.TTL != 0:
+		return nil, fmt.Errorf("destination can not have both a ttl and a retention")
+	case destinationConfig.Retention != nil:
+		policy = destinationConfig.Retention
+	case destinationConfig.TTL != 0:
+		policy = &retention.Policy{KeepWithin: destinationConfig.TTL}
+	}
+
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+func closeDestinations(destinations []Destination) {
+	for _, destination := range destinations {
+		destination.Client.Close()
+	}
+}
+
+// resolveAliases copies a node with every alias replaced by the node it refers to, the anchor may be outside of the node
+func resolveAliases(node *yaml3.Node) *yaml3.Node {
+	if node.Kind == yaml3.AliasNode {
+		return resolveAliases(node.Alias)
+	}
+
+	resolved := *node
+	resolved.Anchor = ""
+	resolved.Content = nil
+	for _, child := range node.Content {
+		resolved.Content = append(resolved.Content, resolveAliases(child))
+	}
+	return &resolved
+}
+
+func createBlobClient(blobStorageType BlobStorageType, blobStorageConfig *yaml3.Node) (BlobClient, error) {
+	// the node is marshaled as it was written so the backends see the original text of every value
+	var config []byte
+	var err error
+	if blobStorageConfig.Kind != 0 {
+		config, err = yaml3.Marshal(resolveAliases(blobStorageConfig))
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling content of blob storage config: %w", err)
+		}
 	}
 
 	var client BlobClient
-	switch strings.ToUpper(string(blobStorageConfig.Type)) {
+	switch strings.ToUpper(string(blobStorageType)) {
 	case string(GCS):
 		client, err = gcs.NewBlobClient(context.Background(), config)
 	case string(S3):
 		client, err = s3.NewBlobClient(config)
+	case string(AZURE):
+		client, err = azure.NewBlobClient(config)
+	case string(FILESYSTEM):
+		client, err = fs.NewBlobClient(config)
+	case string(SFTP):
+		client, err = sftp.NewBlobClient(config)
+	case string(SWIFT):
+		client, err = swift.NewBlobClient(config)
+	case string(WEBDAV):
+		client, err = webdav.NewBlobClient(config)
+	case string(EXEC):
+		client, err = exec.NewBlobClient(config)
 	default:
-		return nil, fmt.Errorf("blob storage config with type %s not supported: %w", blobStorageConfig.Type, err)
+		return nil, fmt.Errorf("blob storage config with type %s not supported: %w", blobStorageType, err)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create blob client %s: %w", blobStorageConfig.Type, err)
+		return nil, fmt.Errorf("failed to create blob client %s: %w", blobStorageType, err)
 	}
 
 	return client, nil
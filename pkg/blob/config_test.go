@@ -0,0 +1,96 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	return configFile
+}
+
+func TestCreateDestinationsFromConfigFileModes(t *testing.T) {
+	directories := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+
+	tests := []struct {
+		name   string
+		config string
+		// modes are the expected modes of the objects in each directory
+		modes map[string]os.FileMode
+	}{
+		{
+			name: "unquoted modes",
+			config: fmt.Sprintf(`type: filesystem
+config:
+  directory: %s
+  file_mode: 0640
+  directory_mode: 0750
+`, directories[0]),
+			modes: map[string]os.FileMode{directories[0]: 0640},
+		},
+		{
+			name: "unquoted mode of destinations sharing an anchor",
+			config: fmt.Sprintf(`destinations:
+  - name: first
+    type: filesystem
+    config:
+      directory: %s
+      file_mode: &mode 0604
+  - name: second
+    type: filesystem
+    config:
+      directory: %s
+      file_mode: *mode
+`, directories[1], directories[2]),
+			modes: map[string]os.FileMode{directories[1]: 0604, directories[2]: 0604},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destinations, err := CreateDestinationsFromConfig(writeConfig(t, test.config), "")
+			if err != nil {
+				t.Fatalf("error creating destinations: %v", err)
+			}
+			defer closeDestinations(destinations)
+
+			if len(destinations) != len(test.modes) {
+				t.Fatalf("created %d destinations, expected %d", len(destinations), len(test.modes))
+			}
+			for _, destination := range destinations {
+				if err := destination.Client.Create(context.Background(), "backup.tar.gz", strings.NewReader("data")); err != nil {
+					t.Fatalf("error creating object in %s: %v", destination.Name, err)
+				}
+			}
+
+			for directory, mode := range test.modes {
+				stat, err := os.Stat(filepath.Join(directory, "backup.tar.gz"))
+				if err != nil {
+					t.Fatalf("error stat object: %v", err)
+				}
+				if stat.Mode().Perm() != mode {
+					t.Errorf("object in %s has mode %o, expected %o", directory, stat.Mode().Perm(), mode)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateDestinationsFromConfigDecimalFileMode(t *testing.T) {
+	config := fmt.Sprintf("type: filesystem\nconfig:\n  directory: %s\n  file_mode: 640\n", t.TempDir())
+
+	_, err := CreateDestinationsFromConfig(writeConfig(t, config), "")
+	if err == nil || !strings.Contains(err.Error(), "quote the mode") {
+		t.Errorf("expected an error asking to quote the mode, got %v", err)
+	}
+}
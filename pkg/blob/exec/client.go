@@ -0,0 +1,422 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
+)
+
+// ProtocolVersion is the version of the plugin protocol sent in every request
+const ProtocolVersion = 1
+
+// maxStderrSize is how much of the plugin stderr is kept for error messages
+const maxStderrSize = 4096
+
+// defaultPageSize is how many list entries are read from the plugin per page when the list options do not set it
+const defaultPageSize = 1000
+
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationRead   Operation = "read"
+	OperationList   Operation = "list"
+	OperationDelete Operation = "delete"
+)
+
+// Request is the first line written to the plugin stdin
+type Request struct {
+	ProtocolVersion int       `json:"protocol_version"`
+	Operation       Operation `json:"operation"`
+	Object          string    `json:"object,omitempty"`
+	// Prefix is set for list requests, plugins that ignore it have the entries filtered after they are read
+	Prefix string      `json:"prefix,omitempty"`
+	Config interface{} `json:"config,omitempty"`
+}
+
+// Response is the first line the plugin writes to stdout
+type Response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// ListEntry is written to stdout by the plugin for every object after the list response
+type ListEntry struct {
+	Name         string            `json:"name"`
+	Size         int64             `json:"size,omitempty"`
+	LastModified time.Time         `json:"last_modified"`
+	ETag         string            `json:"etag,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+type blobStorageConfig struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	// Config is passed to the plugin in every request
+	Config interface{} `yaml:"config"`
+}
+
+type blobClient struct {
+	config       *blobStorageConfig
+	pluginConfig interface{}
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing exec blob storage config: %w", err)
+	}
+
+	if config.Command == "" {
+		return nil, fmt.Errorf("missing command in exec blob storage config")
+	}
+
+	command, err := exec.LookPath(config.Command)
+	if err != nil {
+		return nil, fmt.Errorf("error finding exec blob storage plugin %s: %w", config.Command, err)
+	}
+	config.Command = command
+
+	return &blobClient{
+		config: config,
+		// yaml decodes maps with interface keys which can not be encoded as json
+		pluginConfig: jsonCompatible(config.Config),
+	}, nil
+}
+
+// plugin is a running plugin process handling a single operation
+type plugin struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *limitedBuffer
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+func (bc *blobClient) start(ctx context.Context, operation Operation, objectName string, prefix string) (*plugin, error) {
+	cmd := exec.CommandContext(ctx, bc.config.Command, bc.config.Args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("KUBEADM_BACKUP_PLUGIN_PROTOCOL_VERSION=%d", ProtocolVersion),
+		fmt.Sprintf("KUBEADM_BACKUP_VERSION=%s", version.Version),
+	)
+	for key, value := range bc.config.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	stderr := &limitedBuffer{limit: maxStderrSize}
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating plugin stdin: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating plugin stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting plugin %s: %w", bc.config.Command, err)
+	}
+
+	p := &plugin{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		stderr: stderr,
+	}
+
+	request, err := json.Marshal(Request{
+		ProtocolVersion: ProtocolVersion,
+		Operation:       operation,
+		Object:          objectName,
+		Prefix:          prefix,
+		Config:          bc.pluginConfig,
+	})
+	if err != nil {
+		p.kill()
+		return nil, fmt.Errorf("error encoding plugin request: %w", err)
+	}
+
+	if _, err := stdin.Write(append(request, '\n')); err != nil {
+		return nil, p.fail(fmt.Errorf("error writing plugin request: %w", err))
+	}
+
+	return p, nil
+}
+
+// readResponse reads the response line and turns a plugin error into a go error
+func (p *plugin) readResponse() error {
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return p.fail(fmt.Errorf("error reading plugin response: %w", err))
+	}
+
+	response := &Response{}
+	if err := json.Unmarshal(line, response); err != nil {
+		return p.fail(fmt.Errorf("error parsing plugin response %q: %w", strings.TrimSpace(string(line)), err))
+	}
+
+	if response.Error != "" {
+		return p.fail(errors.New(response.Error))
+	}
+
+	return nil
+}
+
+func (p *plugin) wait() error {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		if p.waitErr != nil {
+			p.waitErr = p.withStderr(fmt.Errorf("plugin %s failed: %w", p.cmd.Path, p.waitErr))
+		}
+	})
+	return p.waitErr
+}
+
+// fail stops the plugin and returns err with what the plugin logged
+func (p *plugin) fail(err error) error {
+	p.kill()
+	return p.withStderr(err)
+}
+
+// kill stops the plugin before closing stdin so it never sees the end of a partial object
+func (p *plugin) kill() {
+	p.cmd.Process.Kill()
+	p.stdin.Close()
+	p.wait()
+}
+
+func (p *plugin) withStderr(err error) error {
+	if stderr := strings.TrimSpace(p.stderr.String()); stderr != "" {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	p, err := bc.start(ctx, OperationCreate, objectName, "")
+	if err != nil {
+		return err
+	}
+
+	stdin := &stdinWriter{writer: p.stdin}
+	if _, err := io.Copy(stdin, reader); err != nil {
+		if stdin.err == nil {
+			return p.fail(fmt.Errorf("error reading object %s: %w", objectName, err))
+		}
+
+		// a plugin that stopped reading early may have written why
+		p.stdin.Close()
+		if responseErr := p.readResponse(); responseErr != nil {
+			return fmt.Errorf("error writing object %s: %w", objectName, responseErr)
+		}
+		return p.fail(fmt.Errorf("error writing object %s to plugin: %w", objectName, err))
+	}
+
+	// closing stdin tells the plugin the whole object has been sent
+	if err := p.stdin.Close(); err != nil {
+		return p.fail(fmt.Errorf("error closing plugin stdin: %w", err))
+	}
+
+	if err := p.readResponse(); err != nil {
+		return fmt.Errorf("error writing object %s: %w", objectName, err)
+	}
+
+	return p.wait()
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	p, err := bc.start(ctx, OperationRead, objectName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.stdin.Close(); err != nil {
+		return nil, p.fail(fmt.Errorf("error closing plugin stdin: %w", err))
+	}
+
+	if err := p.readResponse(); err != nil {
+		return nil, fmt.Errorf("error reading object %s: %w", objectName, err)
+	}
+
+	return &objectReader{plugin: p}, nil
+}
+
+// objectReader returns the object streamed on the plugin stdout, a plugin that exits with an error fails the read
+type objectReader struct {
+	plugin *plugin
+}
+
+func (or *objectReader) Read(p []byte) (int, error) {
+	n, err := or.plugin.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := or.plugin.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (or *objectReader) Close() error {
+	or.plugin.kill()
+	return nil
+}
+
+// List keeps the plugin running between pages, it is killed when ctx is done before every entry was read
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	var p *plugin
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		if p == nil {
+			var err error
+			p, err = bc.start(ctx, OperationList, "", opts.Prefix)
+			if err != nil {
+				return nil, false, err
+			}
+			// the caller may stop iterating before the plugin wrote every entry
+			context.AfterFunc(ctx, p.kill)
+
+			if err := p.stdin.Close(); err != nil {
+				return nil, false, p.fail(fmt.Errorf("error closing plugin stdin: %w", err))
+			}
+
+			if err := p.readResponse(); err != nil {
+				return nil, false, fmt.Errorf("error listing objects: %w", err)
+			}
+		}
+
+		var objects []object.Info
+		for len(objects) < pageSize {
+			line, err := p.stdout.ReadBytes('\n')
+			if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+				return objects, true, p.wait()
+			}
+			if err != nil && err != io.EOF {
+				return nil, false, p.fail(fmt.Errorf("error reading plugin list entry: %w", err))
+			}
+
+			entry := &ListEntry{}
+			if err := json.Unmarshal(line, entry); err != nil {
+				return nil, false, p.fail(fmt.Errorf("error parsing plugin list entry %q: %w", strings.TrimSpace(string(line)), err))
+			}
+
+			if !strings.HasPrefix(entry.Name, opts.Prefix) {
+				continue
+			}
+
+			objects = append(objects, object.Info{
+				Key:          entry.Name,
+				Size:         entry.Size,
+				LastModified: entry.LastModified,
+				ETag:         entry.ETag,
+				Metadata:     entry.Metadata,
+			})
+		}
+
+		return objects, false, nil
+	})
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	p, err := bc.start(ctx, OperationDelete, objectName, "")
+	if err != nil {
+		return err
+	}
+
+	if err := p.stdin.Close(); err != nil {
+		return p.fail(fmt.Errorf("error closing plugin stdin: %w", err))
+	}
+
+	if err := p.readResponse(); err != nil {
+		return fmt.Errorf("error deleting object %s: %w", objectName, err)
+	}
+
+	return p.wait()
+}
+
+func (bc *blobClient) Close() error {
+	return nil
+}
+
+// stdinWriter remembers write errors to tell them apart from errors reading the object
+type stdinWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (sw *stdinWriter) Write(p []byte) (int, error) {
+	n, err := sw.writer.Write(p)
+	if err != nil {
+		sw.err = err
+	}
+	return n, err
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+	limit  int
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	if remaining := lb.limit - lb.buffer.Len(); remaining > 0 {
+		if len(p) > remaining {
+			lb.buffer.Write(p[:remaining])
+		} else {
+			lb.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (lb *limitedBuffer) String() string {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buffer.String()
+}
+
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonCompatible(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
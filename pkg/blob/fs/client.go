@@ -0,0 +1,221 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+)
+
+// tempFilePrefix marks files that are still being written, they are hidden from List
+const tempFilePrefix = ".tmp-"
+
+type blobStorageConfig struct {
+	Directory     string          `yaml:"directory"`
+	FileMode      object.FileMode `yaml:"file_mode"`
+	DirectoryMode object.FileMode `yaml:"directory_mode"`
+}
+
+type blobClient struct {
+	config *blobStorageConfig
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{
+		FileMode:      0600,
+		DirectoryMode: 0700,
+	}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing filesystem blob storage config: %w", err)
+	}
+
+	if config.Directory == "" {
+		return nil, fmt.Errorf("missing directory in filesystem blob storage config")
+	}
+
+	info, err := os.Stat(config.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("error checking filesystem blob storage directory %s: %w", config.Directory, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("filesystem blob storage path %s is not a directory", config.Directory)
+	}
+
+	return &blobClient{
+		config: config,
+	}, nil
+}
+
+// objectPath maps an object name to a path inside the directory, object names use / as the separator
+func (bc *blobClient) objectPath(objectName string) (string, error) {
+	cleanName := path.Clean(objectName)
+	if cleanName == "." || path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", fmt.Errorf("invalid object name %s", objectName)
+	}
+	if strings.HasPrefix(path.Base(cleanName), tempFilePrefix) {
+		return "", fmt.Errorf("invalid object name %s, %s is reserved for temporary files", objectName, tempFilePrefix)
+	}
+
+	return filepath.Join(bc.config.Directory, filepath.FromSlash(cleanName)), nil
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	objectDir := filepath.Dir(objectPath)
+	if err := os.MkdirAll(objectDir, os.FileMode(bc.config.DirectoryMode)); err != nil {
+		return fmt.Errorf("error creating directory %s: %w", objectDir, err)
+	}
+
+	// the object is written to a temporary file next to it and renamed into place so readers never see a partial object
+	tempFile, err := os.CreateTemp(objectDir, tempFilePrefix+filepath.Base(objectPath)+"-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for object %s: %w", objectName, err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, contextReader{ctx: ctx, reader: reader}); err != nil {
+		return fmt.Errorf("error writing object %s: %w", objectName, err)
+	}
+
+	if err := tempFile.Chmod(os.FileMode(bc.config.FileMode)); err != nil {
+		return fmt.Errorf("error setting mode of object %s: %w", objectName, err)
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("error syncing object %s: %w", objectName, err)
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("error closing object %s: %w", objectName, err)
+	}
+
+	if err := os.Rename(tempFile.Name(), objectPath); err != nil {
+		return fmt.Errorf("error renaming object %s into place: %w", objectName, err)
+	}
+
+	return syncDirectory(objectDir)
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening object %s: %w", objectName, err)
+	}
+
+	return f, nil
+}
+
+// List reads a single directory per page, only the directories that can contain objects with the prefix are read
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	directories := []string{""}
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		dir := directories[len(directories)-1]
+		directories = directories[:len(directories)-1]
+
+		dirPath := filepath.Join(bc.config.Directory, filepath.FromSlash(dir))
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			// the directory may have been removed after its parent was read
+			if dir != "" && os.IsNotExist(err) {
+				return nil, len(directories) == 0, nil
+			}
+			return nil, false, fmt.Errorf("error listing directory %s: %w", dirPath, err)
+		}
+
+		var objects []object.Info
+		for _, entry := range entries {
+			objectName := path.Join(dir, entry.Name())
+
+			if entry.IsDir() {
+				if object.DirectoryMayContain(objectName, opts.Prefix) {
+					directories = append(directories, objectName)
+				}
+				continue
+			}
+
+			if strings.HasPrefix(entry.Name(), tempFilePrefix) || !strings.HasPrefix(objectName, opts.Prefix) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, false, fmt.Errorf("error reading object %s: %w", objectName, err)
+			}
+
+			objects = append(objects, object.Info{
+				Key:          objectName,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			})
+		}
+
+		return objects, len(directories) == 0, nil
+	})
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(objectPath); err != nil {
+		return fmt.Errorf("error deleting object %s: %w", objectName, err)
+	}
+
+	return syncDirectory(filepath.Dir(objectPath))
+}
+
+func (bc *blobClient) Close() error {
+	return nil
+}
+
+// syncDirectory makes a rename or remove in the directory durable
+func syncDirectory(directory string) error {
+	d, err := os.Open(directory)
+	if err != nil {
+		return fmt.Errorf("error opening directory %s: %w", directory, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory %s: %w", directory, err)
+	}
+
+	return d.Close()
+}
+
+// contextReader stops a copy when the context is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.reader.Read(p)
+}
@@ -0,0 +1,306 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+)
+
+func newTestClient(t *testing.T, extraConfig string) (*blobClient, string) {
+	t.Helper()
+
+	directory := t.TempDir()
+	client, err := NewBlobClient([]byte(fmt.Sprintf("directory: %s\n%s", directory, extraConfig)))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	return client, directory
+}
+
+func create(t *testing.T, client *blobClient, objectName, content string) {
+	t.Helper()
+
+	if err := client.Create(context.Background(), objectName, strings.NewReader(content)); err != nil {
+		t.Fatalf("error creating %s: %v", objectName, err)
+	}
+}
+
+func listKeys(t *testing.T, client *blobClient, prefix string) []string {
+	t.Helper()
+
+	var keys []string
+	iterator := client.List(context.Background(), object.ListOptions{Prefix: prefix})
+	for {
+		info, err := iterator.Next()
+		if err == object.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error listing %q: %v", prefix, err)
+		}
+		keys = append(keys, info.Key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCreateAndRead(t *testing.T) {
+	client, directory := newTestClient(t, "")
+
+	create(t, client, "nested/dir/backup.tar.gz", "backup data")
+
+	reader, err := client.Read(context.Background(), "nested/dir/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	defer reader.(io.Closer).Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(data) != "backup data" {
+		t.Errorf("read %q, expected %q", data, "backup data")
+	}
+
+	stat, err := os.Stat(filepath.Join(directory, "nested", "dir", "backup.tar.gz"))
+	if err != nil {
+		t.Fatalf("error stat object: %v", err)
+	}
+	if stat.Mode().Perm() != 0600 {
+		t.Errorf("object mode is %o, expected 600", stat.Mode().Perm())
+	}
+
+	stat, err = os.Stat(filepath.Join(directory, "nested"))
+	if err != nil {
+		t.Fatalf("error stat directory: %v", err)
+	}
+	if stat.Mode().Perm() != 0700 {
+		t.Errorf("directory mode is %o, expected 700", stat.Mode().Perm())
+	}
+}
+
+func TestCreateFileMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		fileMode      os.FileMode
+		directoryMode os.FileMode
+	}{
+		{
+			name:          "quoted",
+			config:        "file_mode: \"0640\"\ndirectory_mode: \"0750\"\n",
+			fileMode:      0640,
+			directoryMode: 0750,
+		},
+		{
+			name:          "unquoted",
+			config:        "file_mode: 0640\ndirectory_mode: 0750\n",
+			fileMode:      0640,
+			directoryMode: 0750,
+		},
+		{
+			// 0600 and 0700 are not valid octal numbers once they are written in decimal
+			name:          "unquoted defaults",
+			config:        "file_mode: 0600\ndirectory_mode: 0700\n",
+			fileMode:      0600,
+			directoryMode: 0700,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, directory := newTestClient(t, test.config)
+
+			create(t, client, "nested/backup.tar.gz", "backup data")
+
+			stat, err := os.Stat(filepath.Join(directory, "nested", "backup.tar.gz"))
+			if err != nil {
+				t.Fatalf("error stat object: %v", err)
+			}
+			if stat.Mode().Perm() != test.fileMode {
+				t.Errorf("object mode is %o, expected %o", stat.Mode().Perm(), test.fileMode)
+			}
+
+			stat, err = os.Stat(filepath.Join(directory, "nested"))
+			if err != nil {
+				t.Fatalf("error stat directory: %v", err)
+			}
+			if stat.Mode().Perm() != test.directoryMode {
+				t.Errorf("directory mode is %o, expected %o", stat.Mode().Perm(), test.directoryMode)
+			}
+		})
+	}
+}
+
+func TestInvalidFileMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "unquoted without leading zero", config: "file_mode: 640\n"},
+		{name: "unquoted directory mode without leading zero", config: "directory_mode: 700\n"},
+		{name: "not octal", config: "file_mode: \"0680\"\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewBlobClient([]byte(fmt.Sprintf("directory: %s\n%s", t.TempDir(), test.config))); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestCreateOverwrites(t *testing.T) {
+	client, _ := newTestClient(t, "")
+
+	create(t, client, "backup.tar.gz", "old")
+	create(t, client, "backup.tar.gz", "new")
+
+	reader, err := client.Read(context.Background(), "backup.tar.gz")
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	defer reader.(io.Closer).Close()
+
+	data, _ := io.ReadAll(reader)
+	if string(data) != "new" {
+		t.Errorf("read %q, expected %q", data, "new")
+	}
+}
+
+func TestCreateCancelledLeavesNothing(t *testing.T) {
+	client, directory := newTestClient(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.Create(ctx, "backup.tar.gz", bytes.NewReader(make([]byte, 1024))); err == nil {
+		t.Fatalf("expected an error creating with a cancelled context")
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected an empty directory, found %d entries", len(entries))
+	}
+}
+
+func TestInvalidObjectNames(t *testing.T) {
+	client, _ := newTestClient(t, "")
+
+	for _, objectName := range []string{"", ".", "..", "../escape", "/absolute", ".tmp-backup", "nested/.tmp-backup"} {
+		t.Run(objectName, func(t *testing.T) {
+			if err := client.Create(context.Background(), objectName, strings.NewReader("data")); err == nil {
+				t.Errorf("expected creating %q to fail", objectName)
+			}
+			if _, err := client.Read(context.Background(), objectName); err == nil {
+				t.Errorf("expected reading %q to fail", objectName)
+			}
+			if err := client.Delete(context.Background(), objectName); err == nil {
+				t.Errorf("expected deleting %q to fail", objectName)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	client, directory := newTestClient(t, "")
+
+	create(t, client, "a.tar.gz", "a")
+	create(t, client, "cluster/b.tar.gz", "bb")
+	create(t, client, "cluster/nested/c.tar.gz", "ccc")
+	create(t, client, "other/d.tar.gz", "dddd")
+
+	// a file that is still being written must not be listed
+	if err := os.WriteFile(filepath.Join(directory, "cluster", tempFilePrefix+"e.tar.gz-123"), []byte("partial"), 0600); err != nil {
+		t.Fatalf("error writing temporary file: %v", err)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{
+			prefix:   "",
+			expected: []string{"a.tar.gz", "cluster/b.tar.gz", "cluster/nested/c.tar.gz", "other/d.tar.gz"},
+		},
+		{
+			prefix:   "cluster/",
+			expected: []string{"cluster/b.tar.gz", "cluster/nested/c.tar.gz"},
+		},
+		{
+			prefix:   "cluster/n",
+			expected: []string{"cluster/nested/c.tar.gz"},
+		},
+		{
+			prefix:   "missing/",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			keys := listKeys(t, client, test.prefix)
+			if !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("listed %v, expected %v", keys, test.expected)
+			}
+		})
+	}
+}
+
+func TestListInfo(t *testing.T) {
+	client, _ := newTestClient(t, "")
+
+	create(t, client, "backup.tar.gz", "backup data")
+
+	iterator := client.List(context.Background(), object.ListOptions{})
+	info, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	if info.Key != "backup.tar.gz" || info.Size != int64(len("backup data")) || info.LastModified.IsZero() {
+		t.Errorf("unexpected object info %+v", info)
+	}
+
+	if _, err := iterator.Next(); err != object.Done {
+		t.Errorf("expected the listing to be done, got %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	client, _ := newTestClient(t, "")
+
+	create(t, client, "cluster/a.tar.gz", "a")
+	create(t, client, "cluster/b.tar.gz", "b")
+
+	if err := client.Delete(context.Background(), "cluster/a.tar.gz"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	if keys := listKeys(t, client, ""); !reflect.DeepEqual(keys, []string{"cluster/b.tar.gz"}) {
+		t.Errorf("listed %v after delete", keys)
+	}
+
+	if _, err := client.Read(context.Background(), "cluster/a.tar.gz"); err == nil {
+		t.Errorf("expected reading a deleted object to fail")
+	}
+
+	if err := client.Delete(context.Background(), "cluster/a.tar.gz"); err == nil {
+		t.Errorf("expected deleting a missing object to fail")
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
 )
 
 type blobStorageConfig struct {
@@ -53,7 +56,7 @@ func NewBlobClient(ctx context.Context, rawConfig []byte) (*blobClient, error) {
 	}
 
 	opts = append(opts,
-		option.WithUserAgent(fmt.Sprintf("kubeadm-backup-%s (%s)", "0.0.1", runtime.Version())))
+		option.WithUserAgent(fmt.Sprintf("kubeadm-backup-%s (%s)", version.Version, runtime.Version())))
 
 	gcsClient, err := storage.NewClient(context.Background(), opts...)
 	if err != nil {
@@ -92,33 +95,35 @@ func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, e
 	return obj.NewReader(ctx)
 }
 
-func (bc *blobClient) List(ctx context.Context) <-chan interface{} {
-	objectNamesChan := make(chan interface{}, 1)
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
 	bkt := bc.gcsClient.Bucket(bc.config.Bucket)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: opts.Prefix})
+	it.PageInfo().MaxSize = opts.PageSize
 
-	go func(ctx context.Context, bkt *storage.BucketHandle, objectNamesChan chan<- interface{}) {
-		defer close(objectNamesChan)
-		it := bkt.Objects(ctx, nil)
-		for {
-			attrs, err := it.Next()
-			if err == iterator.Done {
-				return
-			}
-			if err != nil {
-				objectNamesChan <- err
-				return
-			}
-
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				objectNamesChan <- attrs.Name
-			}
-		}
-	}(ctx, bkt, objectNamesChan)
+	return &objectIterator{it: it}
+}
+
+// objectIterator converts the attributes returned by the gcs iterator, it fetches the pages itself
+type objectIterator struct {
+	it *storage.ObjectIterator
+}
+
+func (oi *objectIterator) Next() (object.Info, error) {
+	attrs, err := oi.it.Next()
+	if err == iterator.Done {
+		return object.Info{}, object.Done
+	}
+	if err != nil {
+		return object.Info{}, err
+	}
 
-	return objectNamesChan
+	return object.Info{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		LastModified: attrs.Updated,
+		ETag:         attrs.Etag,
+		Metadata:     attrs.Metadata,
+	}, nil
 }
 
 func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
@@ -0,0 +1,107 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var clusterNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]*[a-zA-Z0-9])?$`)
+
+// ValidateClusterName checks that a cluster name can be used as a single segment of object names
+func ValidateClusterName(clusterName string) error {
+	if !clusterNameRegex.MatchString(clusterName) {
+		return fmt.Errorf("invalid cluster name %q, it may only contain letters, digits, '.', '_' and '-' and must start and end with a letter or digit", clusterName)
+	}
+	return nil
+}
+
+// Namespace returns the namespace objects are stored in, <prefix>/<cluster>/. It is empty when both are empty so
+// objects are stored at the root like before namespaces existed.
+func Namespace(prefix, clusterName string) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		for _, segment := range strings.Split(prefix, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return "", fmt.Errorf("invalid prefix %q", prefix)
+			}
+		}
+	}
+
+	if clusterName != "" {
+		if err := ValidateClusterName(clusterName); err != nil {
+			return "", err
+		}
+	}
+
+	namespace := path.Join(prefix, clusterName)
+	if namespace == "" {
+		return "", nil
+	}
+	return namespace + "/", nil
+}
+
+// namespacedClient stores objects below a namespace so many clusters can share a bucket. Object names are relative
+// to the namespace and only objects directly in it are listed, objects of other namespaces are never seen. This holds
+// for the empty root namespace too, objects below any prefix are skipped.
+type namespacedClient struct {
+	client    BlobClient
+	namespace string
+}
+
+func NewNamespacedClient(client BlobClient, namespace string) BlobClient {
+	return &namespacedClient{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+func (nc *namespacedClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	return nc.client.Create(ctx, nc.namespace+objectName, reader)
+}
+
+func (nc *namespacedClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	return nc.client.Read(ctx, nc.namespace+objectName)
+}
+
+func (nc *namespacedClient) List(ctx context.Context, opts ListOptions) ObjectIterator {
+	opts.Prefix = nc.namespace + opts.Prefix
+	return &namespacedIterator{
+		it:        nc.client.List(ctx, opts),
+		namespace: nc.namespace,
+	}
+}
+
+func (nc *namespacedClient) Delete(ctx context.Context, objectName string) error {
+	return nc.client.Delete(ctx, nc.namespace+objectName)
+}
+
+func (nc *namespacedClient) Close() error {
+	return nc.client.Close()
+}
+
+type namespacedIterator struct {
+	it        ObjectIterator
+	namespace string
+}
+
+func (ni *namespacedIterator) Next() (ObjectInfo, error) {
+	for {
+		info, err := ni.it.Next()
+		if err != nil {
+			return info, err
+		}
+
+		// objects in namespaces nested below this one belong to another prefix or cluster
+		name, ok := strings.CutPrefix(info.Key, ni.namespace)
+		if !ok || strings.Contains(name, "/") {
+			continue
+		}
+
+		info.Key = name
+		return info, nil
+	}
+}
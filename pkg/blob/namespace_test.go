@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/fs"
+)
+
+func TestNamespacedClientList(t *testing.T) {
+	client, err := fs.NewBlobClient([]byte(fmt.Sprintf("directory: %s", t.TempDir())))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	for _, objectName := range []string{
+		"backup-root.tar.gz",
+		"cluster-a/backup-a.tar.gz",
+		"production/cluster-b/backup-b.tar.gz",
+		"production/cluster-b/nested/backup-c.tar.gz",
+	} {
+		if err := client.Create(context.Background(), objectName, strings.NewReader("data")); err != nil {
+			t.Fatalf("error creating %s: %v", objectName, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		expected  []string
+	}{
+		{
+			name:      "root",
+			namespace: "",
+			expected:  []string{"backup-root.tar.gz"},
+		},
+		{
+			name:      "cluster",
+			namespace: "cluster-a/",
+			expected:  []string{"backup-a.tar.gz"},
+		},
+		{
+			name:      "prefix and cluster",
+			namespace: "production/cluster-b/",
+			expected:  []string{"backup-b.tar.gz"},
+		},
+		{
+			name:      "prefix only",
+			namespace: "production/",
+			expected:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var keys []string
+			iterator := NewNamespacedClient(client, test.namespace).List(context.Background(), ListOptions{})
+			for {
+				info, err := iterator.Next()
+				if err == IteratorDone {
+					break
+				}
+				if err != nil {
+					t.Fatalf("error listing: %v", err)
+				}
+				keys = append(keys, info.Key)
+			}
+			sort.Strings(keys)
+
+			if !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("listed %v, expected %v", keys, test.expected)
+			}
+		})
+	}
+}
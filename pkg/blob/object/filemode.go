@@ -0,0 +1,32 @@
+package object
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileMode is a file permission written as an octal string in the config, like "0600"
+type FileMode os.FileMode
+
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	// an unquoted mode is read as a number, yaml only reads it as octal when it has a leading 0
+	var number uint32
+	if err := unmarshal(&number); err == nil && !strings.HasPrefix(raw, "0") {
+		return fmt.Errorf("file mode %s is read as the decimal number %d, quote the mode like \"0%s\"", raw, number, raw)
+	}
+
+	mode, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil {
+		return fmt.Errorf("error parsing file mode %s: %w", raw, err)
+	}
+
+	*m = FileMode(os.FileMode(mode).Perm())
+	return nil
+}
@@ -0,0 +1,87 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Done is returned by Iterator.Next once every object has been returned
+var Done = errors.New("no more objects in listing")
+
+// Info describes an object in blob storage
+type Info struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag is empty when the blob storage does not have one
+	ETag     string
+	Metadata map[string]string
+}
+
+type ListOptions struct {
+	// Prefix limits the listing to objects whose key starts with it
+	Prefix string
+	// PageSize is how many objects are requested at once, 0 uses the default of the blob storage. Blob storages that
+	// list one directory per page ignore it.
+	PageSize int
+}
+
+// DirectoryMayContain returns whether objects in directory or below it can start with prefix, so directories that
+// can not contain any can be skipped when listing
+func DirectoryMayContain(directory, prefix string) bool {
+	directory += "/"
+	return strings.HasPrefix(directory, prefix) || strings.HasPrefix(prefix, directory)
+}
+
+// Iterator returns the objects of a listing one at a time, pages are only fetched when they are needed
+type Iterator interface {
+	Next() (Info, error)
+}
+
+// PageFunc fetches the next page of a listing, last is true when there are no pages after it
+type PageFunc func(ctx context.Context) (objects []Info, last bool, err error)
+
+type pageIterator struct {
+	ctx   context.Context
+	fetch PageFunc
+
+	page []Info
+	last bool
+	err  error
+}
+
+// NewPageIterator returns an iterator calling fetch whenever the objects of the previous page have been returned
+func NewPageIterator(ctx context.Context, fetch PageFunc) Iterator {
+	return &pageIterator{
+		ctx:   ctx,
+		fetch: fetch,
+	}
+}
+
+// NewErrorIterator returns an iterator that fails with err, for listings that can not be started
+func NewErrorIterator(err error) Iterator {
+	return &pageIterator{err: err}
+}
+
+func (pi *pageIterator) Next() (Info, error) {
+	for len(pi.page) == 0 {
+		if pi.err != nil {
+			return Info{}, pi.err
+		}
+		if pi.last {
+			return Info{}, Done
+		}
+		if err := pi.ctx.Err(); err != nil {
+			pi.err = err
+			return Info{}, err
+		}
+
+		pi.page, pi.last, pi.err = pi.fetch(pi.ctx)
+	}
+
+	info := pi.page[0]
+	pi.page = pi.page[1:]
+	return info, nil
+}
@@ -8,14 +8,20 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v6"
 	"github.com/minio/minio-go/v6/pkg/credentials"
 	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
 )
 
 var defaultConfig = blobStorageConfig{
+	// the minio client buffers a whole part in memory when the object size is unknown
+	PartSize: 16 * 1024 * 1024,
 	HTTPConfig: httpConfig{
 		IdleConnTimeout:       90 * time.Second,
 		ResponseHeaderTimeout: 2 * time.Minute,
@@ -34,6 +40,7 @@ type blobStorageConfig struct {
 	Bucket    string `yaml:"bucket"`
 	AccessKey string `yaml:"access_key"`
 	SecretKey string `yaml:"secret_key"`
+	PartSize  uint64 `yaml:"part_size"`
 
 	HTTPConfig httpConfig `json:"http_config"`
 }
@@ -44,7 +51,9 @@ type blobClient struct {
 }
 
 func NewBlobClient(rawConfig []byte) (*blobClient, error) {
-	config := &defaultConfig
+	// copy the defaults so every destination gets its own config
+	defaults := defaultConfig
+	config := &defaults
 	err := yaml.Unmarshal(rawConfig, config)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing s3 blob storage config: %w", err)
@@ -77,7 +86,7 @@ func NewBlobClient(rawConfig []byte) (*blobClient, error) {
 		return nil, fmt.Errorf("error creating s3 client: %w", err)
 	}
 
-	minioClient.SetAppInfo("kube-baremetal", fmt.Sprintf("%s (%s)", "0.0.1", runtime.Version()))
+	minioClient.SetAppInfo("kube-baremetal", fmt.Sprintf("%s (%s)", version.Version, runtime.Version()))
 	minioClient.SetCustomTransport(&http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -110,7 +119,7 @@ func NewBlobClient(rawConfig []byte) (*blobClient, error) {
 }
 
 func (b *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
-	_, err := b.minioClient.PutObjectWithContext(ctx, b.config.Bucket, objectName, reader, -1, minio.PutObjectOptions{})
+	_, err := b.minioClient.PutObjectWithContext(ctx, b.config.Bucket, objectName, reader, -1, minio.PutObjectOptions{PartSize: b.config.PartSize})
 	return err
 }
 
@@ -118,28 +127,30 @@ func (b *blobClient) Read(ctx context.Context, objectName string) (io.Reader, er
 	return b.minioClient.GetObjectWithContext(ctx, b.config.Bucket, objectName, minio.GetObjectOptions{})
 }
 
-func (b *blobClient) List(ctx context.Context) <-chan interface{} {
-	objectNamesChan := make(chan interface{}, 1)
-
-	go func(ctx context.Context, objectNamesChan chan<- interface{}) {
-		defer close(objectNamesChan)
-		objectCh := b.minioClient.ListObjectsV2(b.config.Bucket, "", false, ctx.Done())
-		for obj := range objectCh {
-			if obj.Err != nil {
-				objectNamesChan <- obj.Err
-				return
-			}
-
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				objectNamesChan <- obj.Key
-			}
+func (b *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	core := minio.Core{Client: b.minioClient}
+	continuationToken := ""
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		result, err := core.ListObjectsV2(b.config.Bucket, opts.Prefix, continuationToken, false, "", opts.PageSize, "")
+		if err != nil {
+			return nil, false, fmt.Errorf("error listing bucket %s: %w", b.config.Bucket, err)
+		}
+		continuationToken = result.NextContinuationToken
+
+		objects := make([]object.Info, 0, len(result.Contents))
+		for _, obj := range result.Contents {
+			objects = append(objects, object.Info{
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         strings.Trim(obj.ETag, "\""),
+				Metadata:     obj.UserMetadata,
+			})
 		}
-	}(ctx, objectNamesChan)
 
-	return objectNamesChan
+		return objects, !result.IsTruncated, nil
+	})
 }
 
 func (b *blobClient) Delete(ctx context.Context, objectName string) error {
@@ -158,5 +169,6 @@ func (b *blobClient) Delete(ctx context.Context, objectName string) error {
 }
 
 func (b *blobClient) Close() error {
-	panic("implement me")
+	// the minio client has nothing to release
+	return nil
 }
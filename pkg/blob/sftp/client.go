@@ -0,0 +1,384 @@
+package sftp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
+)
+
+// tempFilePrefix marks files that are still being uploaded, they are hidden from List
+const tempFilePrefix = ".tmp-"
+
+type blobStorageConfig struct {
+	Address string `yaml:"address"`
+	User    string `yaml:"user"`
+
+	PrivateKey           string `yaml:"private_key"`
+	PrivateKeyFile       string `yaml:"private_key_file"`
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase"`
+
+	KnownHostsFile        string `yaml:"known_hosts_file"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+
+	Directory     string          `yaml:"directory"`
+	FileMode      object.FileMode `yaml:"file_mode"`
+	DirectoryMode object.FileMode `yaml:"directory_mode"`
+
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type blobClient struct {
+	config    *blobStorageConfig
+	sshConfig *ssh.ClientConfig
+
+	lock       sync.Mutex
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{
+		FileMode:      0600,
+		DirectoryMode: 0700,
+		Timeout:       30 * time.Second,
+	}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sftp blob storage config: %w", err)
+	}
+
+	if config.Address == "" {
+		return nil, fmt.Errorf("missing address in sftp blob storage config")
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		config.Address = net.JoinHostPort(config.Address, "22")
+	}
+
+	if config.User == "" {
+		return nil, fmt.Errorf("missing user in sftp blob storage config")
+	}
+
+	if config.Directory == "" {
+		return nil, fmt.Errorf("missing directory in sftp blob storage config")
+	}
+	config.Directory = path.Clean(config.Directory)
+
+	signer, err := privateKeySigner(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case config.KnownHostsFile != "":
+		hostKeyCallback, err = knownhosts.New(config.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading sftp known hosts file %s: %w", config.KnownHostsFile, err)
+		}
+	case config.InsecureIgnoreHostKey:
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, fmt.Errorf("missing known_hosts_file in sftp blob storage config")
+	}
+
+	return &blobClient{
+		config: config,
+		sshConfig: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			ClientVersion:   fmt.Sprintf("SSH-2.0-kubeadm-backup-%s", version.Version),
+			Timeout:         config.Timeout,
+		},
+	}, nil
+}
+
+func privateKeySigner(config *blobStorageConfig) (ssh.Signer, error) {
+	privateKey := []byte(config.PrivateKey)
+	if config.PrivateKeyFile != "" {
+		var err error
+		privateKey, err = os.ReadFile(config.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading sftp private key file %s: %w", config.PrivateKeyFile, err)
+		}
+	}
+
+	if len(privateKey) == 0 {
+		return nil, fmt.Errorf("missing private_key or private_key_file in sftp blob storage config")
+	}
+
+	var signer ssh.Signer
+	var err error
+	if config.PrivateKeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(config.PrivateKeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(privateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sftp private key: %w", err)
+	}
+
+	return signer, nil
+}
+
+// client returns the sftp session, connecting again when the previous connection was lost
+func (bc *blobClient) client(ctx context.Context) (*sftp.Client, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.sftpClient != nil {
+		return bc.sftpClient, nil
+	}
+
+	dialer := &net.Dialer{Timeout: bc.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", bc.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to sftp server %s: %w", bc.config.Address, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, bc.config.Address, bc.sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening ssh connection to %s: %w", bc.config.Address, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("error starting sftp session on %s: %w", bc.config.Address, err)
+	}
+
+	bc.sshClient = sshClient
+	bc.sftpClient = sftpClient
+
+	go func() {
+		sshClient.Wait()
+
+		bc.lock.Lock()
+		defer bc.lock.Unlock()
+		if bc.sshClient == sshClient {
+			bc.sshClient = nil
+			bc.sftpClient = nil
+		}
+	}()
+
+	return sftpClient, nil
+}
+
+// objectPath maps an object name to a path inside the remote directory
+func (bc *blobClient) objectPath(objectName string) (string, error) {
+	cleanName := path.Clean(objectName)
+	if cleanName == "." || path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", fmt.Errorf("invalid object name %s", objectName)
+	}
+	if strings.HasPrefix(path.Base(cleanName), tempFilePrefix) {
+		return "", fmt.Errorf("invalid object name %s, %s is reserved for temporary files", objectName, tempFilePrefix)
+	}
+
+	return path.Join(bc.config.Directory, cleanName), nil
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	client, err := bc.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	objectDir := path.Dir(objectPath)
+	if err := bc.mkdirAll(client, objectDir); err != nil {
+		return fmt.Errorf("error creating remote directory %s: %w", objectDir, err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("error generating temporary object name: %w", err)
+	}
+
+	// the object is uploaded under a temporary name and renamed into place so a partial upload is never listed
+	tempPath := path.Join(objectDir, tempFilePrefix+path.Base(objectPath)+"-"+hex.EncodeToString(suffix))
+	f, err := client.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("error creating remote file %s: %w", tempPath, err)
+	}
+	defer client.Remove(tempPath)
+	defer f.Close()
+
+	// the server creates the file with its own default mode, it is restricted before any backup data is written
+	if err := f.Chmod(os.FileMode(bc.config.FileMode)); err != nil {
+		return fmt.Errorf("error setting mode of object %s: %w", objectName, err)
+	}
+
+	// closing the file unblocks a write that is stuck on a dead connection
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("error writing object %s: %w", objectName, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing object %s: %w", objectName, err)
+	}
+
+	// not every server supports the posix-rename extension which is needed to replace an existing object
+	if err := client.PosixRename(tempPath, objectPath); err != nil {
+		if err := client.Rename(tempPath, objectPath); err != nil {
+			return fmt.Errorf("error renaming object %s into place: %w", objectName, err)
+		}
+	}
+
+	return nil
+}
+
+// mkdirAll creates the missing parents of an object with the configured directory mode
+func (bc *blobClient) mkdirAll(client *sftp.Client, dir string) error {
+	if info, err := client.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+
+	if parent := path.Dir(dir); parent != dir {
+		if err := bc.mkdirAll(client, parent); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mkdir(dir); err != nil {
+		// another master may have created it at the same time
+		if info, statErr := client.Stat(dir); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	return client.Chmod(dir, os.FileMode(bc.config.DirectoryMode))
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := bc.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening object %s: %w", objectName, err)
+	}
+
+	return f, nil
+}
+
+// List reads a single remote directory per page, only the directories that can contain objects with the prefix are read
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	directories := []string{""}
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		client, err := bc.client(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+
+		dir := directories[len(directories)-1]
+		directories = directories[:len(directories)-1]
+
+		dirPath := path.Join(bc.config.Directory, dir)
+		entries, err := client.ReadDir(dirPath)
+		if err != nil {
+			// the directory may have been removed after its parent was read
+			if dir != "" && errors.Is(err, os.ErrNotExist) {
+				return nil, len(directories) == 0, nil
+			}
+			return nil, false, fmt.Errorf("error listing remote directory %s: %w", dirPath, err)
+		}
+
+		var objects []object.Info
+		for _, entry := range entries {
+			objectName := path.Join(dir, entry.Name())
+
+			if entry.IsDir() {
+				if object.DirectoryMayContain(objectName, opts.Prefix) {
+					directories = append(directories, objectName)
+				}
+				continue
+			}
+
+			if strings.HasPrefix(entry.Name(), tempFilePrefix) || !strings.HasPrefix(objectName, opts.Prefix) {
+				continue
+			}
+
+			objects = append(objects, object.Info{
+				Key:          objectName,
+				Size:         entry.Size(),
+				LastModified: entry.ModTime(),
+			})
+		}
+
+		return objects, len(directories) == 0, nil
+	})
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	objectPath, err := bc.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	client, err := bc.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Remove(objectPath); err != nil {
+		return fmt.Errorf("error deleting object %s: %w", objectName, err)
+	}
+
+	return nil
+}
+
+func (bc *blobClient) Close() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.sshClient == nil {
+		return nil
+	}
+
+	bc.sftpClient.Close()
+	err := bc.sshClient.Close()
+	bc.sshClient = nil
+	bc.sftpClient = nil
+	return err
+}
@@ -0,0 +1,285 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+)
+
+// startTestServer runs an in process ssh server with the sftp subsystem, it accepts the returned private key
+func startTestServer(t *testing.T) (string, []byte) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("error creating host signer: %v", err)
+	}
+
+	clientPublicKey, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating client key: %v", err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatalf("error creating client public key: %v", err)
+	}
+	clientKeyPEM, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatalf("error marshaling client key: %v", err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "backup" && string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, serverConfig)
+		}
+	}()
+
+	return listener.Addr().String(), pem.EncodeToMemory(clientKeyPEM)
+}
+
+func serveConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+func newTestClient(t *testing.T) (*blobClient, string) {
+	t.Helper()
+
+	address, privateKey := startTestServer(t)
+	directory := t.TempDir()
+
+	config := fmt.Sprintf("address: %s\nuser: backup\ninsecure_ignore_host_key: true\ndirectory: %s\nfile_mode: \"0640\"\nprivate_key: |\n", address, directory)
+	for _, line := range strings.Split(strings.TrimSpace(string(privateKey)), "\n") {
+		config += "  " + line + "\n"
+	}
+
+	client, err := NewBlobClient([]byte(config))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client, directory
+}
+
+func listKeys(t *testing.T, client *blobClient, prefix string) []string {
+	t.Helper()
+
+	var keys []string
+	iterator := client.List(context.Background(), object.ListOptions{Prefix: prefix})
+	for {
+		info, err := iterator.Next()
+		if err == object.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error listing %q: %v", prefix, err)
+		}
+		keys = append(keys, info.Key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRoundTrip(t *testing.T) {
+	client, directory := newTestClient(t)
+	ctx := context.Background()
+
+	for objectName, content := range map[string]string{
+		"a.tar.gz":                "a",
+		"cluster/b.tar.gz":        "bb",
+		"cluster/nested/c.tar.gz": "ccc",
+	} {
+		if err := client.Create(ctx, objectName, strings.NewReader(content)); err != nil {
+			t.Fatalf("error creating %s: %v", objectName, err)
+		}
+	}
+
+	stat, err := os.Stat(filepath.Join(directory, "cluster", "b.tar.gz"))
+	if err != nil {
+		t.Fatalf("error stat object: %v", err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("object mode is %o, expected 640", stat.Mode().Perm())
+	}
+
+	stat, err = os.Stat(filepath.Join(directory, "cluster", "nested"))
+	if err != nil {
+		t.Fatalf("error stat directory: %v", err)
+	}
+	if stat.Mode().Perm() != 0700 {
+		t.Errorf("directory mode is %o, expected 700", stat.Mode().Perm())
+	}
+
+	// a file that is still being uploaded must not be listed
+	if err := os.WriteFile(filepath.Join(directory, "cluster", tempFilePrefix+"d.tar.gz-123"), []byte("partial"), 0600); err != nil {
+		t.Fatalf("error writing temporary file: %v", err)
+	}
+
+	if keys := listKeys(t, client, ""); !reflect.DeepEqual(keys, []string{"a.tar.gz", "cluster/b.tar.gz", "cluster/nested/c.tar.gz"}) {
+		t.Errorf("listed %v", keys)
+	}
+	if keys := listKeys(t, client, "cluster/n"); !reflect.DeepEqual(keys, []string{"cluster/nested/c.tar.gz"}) {
+		t.Errorf("listed %v with a prefix", keys)
+	}
+
+	reader, err := client.Read(ctx, "cluster/nested/c.tar.gz")
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.(io.Closer).Close()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(data) != "ccc" {
+		t.Errorf("read %q, expected %q", data, "ccc")
+	}
+
+	// replacing an existing object needs the posix-rename extension
+	if err := client.Create(ctx, "a.tar.gz", strings.NewReader("new")); err != nil {
+		t.Fatalf("error replacing object: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(directory, "a.tar.gz"))
+	if err != nil || string(data) != "new" {
+		t.Errorf("replaced object contains %q, error %v", data, err)
+	}
+
+	if err := client.Delete(ctx, "cluster/b.tar.gz"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if keys := listKeys(t, client, "cluster/"); !reflect.DeepEqual(keys, []string{"cluster/nested/c.tar.gz"}) {
+		t.Errorf("listed %v after delete", keys)
+	}
+	if err := client.Delete(ctx, "cluster/b.tar.gz"); err == nil {
+		t.Errorf("expected deleting a missing object to fail")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(directory, "cluster"))
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempFilePrefix) && entry.Name() != tempFilePrefix+"d.tar.gz-123" {
+			t.Errorf("temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+
+	if err := client.Create(ctx, "a.tar.gz", strings.NewReader("a")); err != nil {
+		t.Fatalf("error creating: %v", err)
+	}
+
+	// drop the connection like a server restart would
+	client.lock.Lock()
+	client.sshClient.Close()
+	client.lock.Unlock()
+
+	// the connection is reset in the background once the ssh client noticed it is gone
+	for i := 0; i < 100; i++ {
+		client.lock.Lock()
+		reset := client.sftpClient == nil
+		client.lock.Unlock()
+		if reset {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if keys := listKeys(t, client, ""); !reflect.DeepEqual(keys, []string{"a.tar.gz"}) {
+		t.Errorf("listed %v after reconnecting", keys)
+	}
+}
+
+func TestInvalidObjectNames(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	for _, objectName := range []string{"", "..", "../escape", "/absolute", ".tmp-backup", "nested/.tmp-backup"} {
+		t.Run(objectName, func(t *testing.T) {
+			if err := client.Create(context.Background(), objectName, strings.NewReader("data")); err == nil {
+				t.Errorf("expected creating %q to fail", objectName)
+			}
+		})
+	}
+}
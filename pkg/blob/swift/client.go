@@ -0,0 +1,304 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/ncw/swift/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
+)
+
+const contentType = "application/octet-stream"
+
+// defaultMaxManifestSegments is the max_manifest_segments of swift, it is used when the cluster does not report it
+const defaultMaxManifestSegments = 1000
+
+type blobStorageConfig struct {
+	AuthURL string `yaml:"auth_url"`
+	Region  string `yaml:"region"`
+
+	UserName          string `yaml:"user_name"`
+	UserDomainName    string `yaml:"user_domain_name"`
+	Password          string `yaml:"password"`
+	ProjectName       string `yaml:"project_name"`
+	ProjectID         string `yaml:"project_id"`
+	ProjectDomainName string `yaml:"project_domain_name"`
+
+	ApplicationCredentialID     string `yaml:"application_credential_id"`
+	ApplicationCredentialName   string `yaml:"application_credential_name"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+
+	Container        string `yaml:"container"`
+	SegmentContainer string `yaml:"segment_container"`
+	SegmentSize      int64  `yaml:"segment_size"`
+
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type blobClient struct {
+	config *blobStorageConfig
+	conn   *swift.Connection
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{
+		// the first segment is read into memory to decide if a large object is needed and the swift library buffers
+		// every segment it uploads, so up to two segments are held in memory. With the default of 1000 segments per
+		// manifest this allows backups of up to 32000 MiB.
+		SegmentSize: 32 * 1024 * 1024,
+		Timeout:     60 * time.Second,
+	}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing swift blob storage config: %w", err)
+	}
+
+	if config.Container == "" {
+		return nil, fmt.Errorf("missing swift container name in blob storage config")
+	}
+
+	if config.SegmentContainer == "" {
+		config.SegmentContainer = config.Container + "_segments"
+	}
+
+	if config.SegmentSize <= 0 {
+		return nil, fmt.Errorf("swift segment size must be greater than 0")
+	}
+
+	if config.SegmentContainer == config.Container {
+		return nil, fmt.Errorf("swift segment container must be different from the container")
+	}
+
+	conn := &swift.Connection{
+		AuthUrl:     config.AuthURL,
+		AuthVersion: 3,
+		Region:      config.Region,
+
+		UserName:     config.UserName,
+		Domain:       config.UserDomainName,
+		ApiKey:       config.Password,
+		Tenant:       config.ProjectName,
+		TenantId:     config.ProjectID,
+		TenantDomain: config.ProjectDomainName,
+
+		ApplicationCredentialId:     config.ApplicationCredentialID,
+		ApplicationCredentialName:   config.ApplicationCredentialName,
+		ApplicationCredentialSecret: config.ApplicationCredentialSecret,
+
+		UserAgent: fmt.Sprintf("kubeadm-backup-%s (%s)", version.Version, runtime.Version()),
+		Timeout:   config.Timeout,
+	}
+
+	// anything not set in the config is read from the OS_ environment variables like the openstack cli does
+	if err := conn.ApplyEnvironment(); err != nil {
+		return nil, fmt.Errorf("error reading swift settings from the environment: %w", err)
+	}
+
+	if conn.AuthUrl == "" {
+		return nil, fmt.Errorf("missing swift auth_url in blob storage config")
+	}
+
+	return &blobClient{
+		config: config,
+		conn:   conn,
+	}, nil
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	segment := make([]byte, bc.config.SegmentSize)
+	n, err := io.ReadFull(reader, segment)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the object fits in a single segment so it does not need to be a large object
+		_, err = bc.conn.ObjectPut(ctx, bc.config.Container, objectName, bytes.NewReader(segment[:n]), true, "", contentType, nil)
+		if err != nil {
+			return fmt.Errorf("error writing object %s to container %s: %w", objectName, bc.config.Container, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading object %s: %w", objectName, err)
+	}
+
+	if err := bc.createStaticLargeObject(ctx, objectName, segment, reader); err != nil {
+		// segments of a failed upload are not referenced by a manifest so nothing else would remove them
+		if cleanupErr := bc.deleteSegments(ctx, objectName); cleanupErr != nil {
+			return fmt.Errorf("%w, error cleaning up segments: %v", err, cleanupErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// createStaticLargeObject uploads the object as segments in the segment container and then writes a manifest listing them
+func (bc *blobClient) createStaticLargeObject(ctx context.Context, objectName string, firstSegment []byte, reader io.Reader) error {
+	maxSegments, err := bc.maxManifestSegments(ctx)
+	if err != nil {
+		return err
+	}
+	// the manifest of an object with more segments would be refused after every segment was uploaded
+	maxSize := maxSegments * bc.config.SegmentSize
+
+	if err := bc.conn.ContainerCreate(ctx, bc.config.SegmentContainer, nil); err != nil {
+		return fmt.Errorf("error creating segment container %s: %w", bc.config.SegmentContainer, err)
+	}
+
+	largeObject, err := bc.conn.StaticLargeObjectCreate(ctx, &swift.LargeObjectOpts{
+		Container:        bc.config.Container,
+		ObjectName:       objectName,
+		ContentType:      contentType,
+		ChunkSize:        bc.config.SegmentSize,
+		SegmentContainer: bc.config.SegmentContainer,
+		SegmentPrefix:    objectName,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating large object %s in container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	if _, err := largeObject.Write(firstSegment); err != nil {
+		return fmt.Errorf("error writing object %s to container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	reader = &sizeLimitReader{
+		reader:    contextReader{ctx: ctx, reader: reader},
+		remaining: maxSize - int64(len(firstSegment)),
+		err: fmt.Errorf("object is larger than %d bytes, the most %d segments of %d bytes can hold, increase segment_size",
+			maxSize, maxSegments, bc.config.SegmentSize),
+	}
+	if _, err := io.Copy(largeObject, reader); err != nil {
+		return fmt.Errorf("error writing object %s to container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	if err := largeObject.CloseWithContext(ctx); err != nil {
+		return fmt.Errorf("error writing manifest of object %s to container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	return nil
+}
+
+// maxManifestSegments returns how many segments the manifest of a static large object may list
+func (bc *blobClient) maxManifestSegments(ctx context.Context) (int64, error) {
+	info, err := bc.conn.QueryInfo(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error querying swift info: %w", err)
+	}
+
+	if slo, ok := info["slo"].(map[string]interface{}); ok {
+		if maxSegments, ok := slo["max_manifest_segments"].(float64); ok && maxSegments > 0 {
+			return int64(maxSegments), nil
+		}
+	}
+	return defaultMaxManifestSegments, nil
+}
+
+func (bc *blobClient) deleteSegments(ctx context.Context, objectName string) error {
+	segmentNames, err := bc.conn.ObjectNamesAll(ctx, bc.config.SegmentContainer, &swift.ObjectsOpts{Prefix: objectName + "/"})
+	if err != nil {
+		if errors.Is(err, swift.ContainerNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for _, segmentName := range segmentNames {
+		if err := bc.conn.ObjectDelete(ctx, bc.config.SegmentContainer, segmentName); err != nil && !errors.Is(err, swift.ObjectNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	// the etag of a large object is not the md5 of its content so it can not be checked
+	file, _, err := bc.conn.ObjectOpen(ctx, bc.config.Container, objectName, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s from container %s: %w", objectName, bc.config.Container, err)
+	}
+
+	return file, nil
+}
+
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	marker := ""
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		swiftObjects, err := bc.conn.Objects(ctx, bc.config.Container, &swift.ObjectsOpts{
+			Prefix: opts.Prefix,
+			Limit:  opts.PageSize,
+			Marker: marker,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("error listing container %s: %w", bc.config.Container, err)
+		}
+
+		// swift has no continuation token, the listing ends with an empty page
+		if len(swiftObjects) == 0 {
+			return nil, true, nil
+		}
+		marker = swiftObjects[len(swiftObjects)-1].Name
+
+		objects := make([]object.Info, 0, len(swiftObjects))
+		for _, swiftObject := range swiftObjects {
+			objects = append(objects, object.Info{
+				Key:          swiftObject.Name,
+				Size:         swiftObject.Bytes,
+				LastModified: swiftObject.LastModified,
+				ETag:         swiftObject.Hash,
+			})
+		}
+
+		return objects, false, nil
+	})
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	// this removes the segments of a large object as well
+	return bc.conn.LargeObjectDelete(ctx, bc.config.Container, objectName)
+}
+
+func (bc *blobClient) Close() error {
+	return nil
+}
+
+// contextReader stops a copy when the context is cancelled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.reader.Read(p)
+}
+
+// sizeLimitReader fails with err once more than remaining bytes are read
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (sr *sizeLimitReader) Read(p []byte) (int, error) {
+	// one byte past the limit is enough to know the object is too large
+	if int64(len(p)) > sr.remaining+1 {
+		p = p[:sr.remaining+1]
+	}
+
+	n, err := sr.reader.Read(p)
+	sr.remaining -= int64(n)
+	if sr.remaining < 0 {
+		return 0, sr.err
+	}
+	return n, err
+}
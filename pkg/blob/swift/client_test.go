@@ -0,0 +1,520 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+)
+
+const (
+	testToken   = "test-token"
+	testAccount = "/v1/AUTH_test"
+)
+
+type fakeSegment struct {
+	Path string `json:"path"`
+	Etag string `json:"etag"`
+	Size int64  `json:"size_bytes"`
+}
+
+type fakeObject struct {
+	data         []byte
+	manifest     []fakeSegment
+	lastModified time.Time
+}
+
+// fakeSwift is a keystone v3 and swift server that keeps objects in memory, it supports just enough of both apis for
+// the swift library to create, list, read and delete static large objects
+type fakeSwift struct {
+	lock       sync.Mutex
+	containers map[string]map[string]*fakeObject
+
+	// failPut makes the upload of an object fail
+	failPut func(container, objectName string) bool
+	// maxManifestSegments is reported in the info and enforced for manifests when it is set
+	maxManifestSegments int
+	manifestPuts        int
+
+	server *httptest.Server
+}
+
+func newFakeSwift(t *testing.T) *fakeSwift {
+	t.Helper()
+
+	fs := &fakeSwift{
+		containers: map[string]map[string]*fakeObject{},
+	}
+	fs.server = httptest.NewServer(http.HandlerFunc(fs.handle))
+	t.Cleanup(fs.server.Close)
+
+	return fs
+}
+
+func (fs *fakeSwift) handle(w http.ResponseWriter, req *http.Request) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	switch {
+	case req.URL.Path == "/v3/auth/tokens" && req.Method == http.MethodPost:
+		fs.auth(w)
+	case req.URL.Path == "/info" && req.Method == http.MethodGet:
+		slo := map[string]interface{}{"min_segment_size": 1}
+		if fs.maxManifestSegments > 0 {
+			slo["max_manifest_segments"] = fs.maxManifestSegments
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"slo": slo})
+	case strings.HasPrefix(req.URL.Path, testAccount+"/"):
+		if req.Header.Get("X-Auth-Token") != testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		container, objectName, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, testAccount+"/"), "/")
+		if objectName == "" {
+			fs.handleContainer(w, req, container)
+		} else {
+			fs.handleObject(w, req, container, objectName)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (fs *fakeSwift) auth(w http.ResponseWriter) {
+	w.Header().Set("X-Subject-Token", testToken)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token": map[string]interface{}{
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			"catalog": []interface{}{
+				map[string]interface{}{
+					"type": "object-store",
+					"endpoints": []interface{}{
+						map[string]interface{}{
+							"interface": "public",
+							"region":    "RegionOne",
+							"url":       fs.server.URL + testAccount,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (fs *fakeSwift) handleContainer(w http.ResponseWriter, req *http.Request, container string) {
+	switch req.Method {
+	case http.MethodPut:
+		if _, ok := fs.containers[container]; !ok {
+			fs.containers[container] = map[string]*fakeObject{}
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		objects, ok := fs.containers[container]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		query := req.URL.Query()
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		var names []string
+		for name := range objects {
+			if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if limit > 0 && len(names) > limit {
+			names = names[:limit]
+		}
+
+		if query.Get("format") != "json" {
+			if len(names) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, strings.Join(names, "\n")+"\n")
+			return
+		}
+
+		listing := []map[string]interface{}{}
+		for _, name := range names {
+			obj := objects[name]
+			listing = append(listing, map[string]interface{}{
+				"name":          name,
+				"bytes":         fs.size(obj),
+				"hash":          fs.etag(obj),
+				"content_type":  contentType,
+				"last_modified": obj.lastModified.UTC().Format("2006-01-02T15:04:05.000000"),
+			})
+		}
+		writeJSON(w, http.StatusOK, listing)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (fs *fakeSwift) handleObject(w http.ResponseWriter, req *http.Request, container, objectName string) {
+	objects, ok := fs.containers[container]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if fs.failPut != nil && fs.failPut(container, objectName) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		obj := &fakeObject{data: data, lastModified: time.Now()}
+		if req.URL.Query().Get("multipart-manifest") == "put" {
+			fs.manifestPuts++
+			if err := json.Unmarshal(data, &obj.manifest); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if fs.maxManifestSegments > 0 && len(obj.manifest) > fs.maxManifestSegments {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, segment := range obj.manifest {
+				segmentContainer, segmentName, _ := strings.Cut(segment.Path, "/")
+				segmentObj, ok := fs.containers[segmentContainer][segmentName]
+				if !ok || int64(len(segmentObj.data)) != segment.Size || fs.etag(segmentObj) != segment.Etag {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			obj.data = nil
+		}
+		objects[objectName] = obj
+
+		w.Header().Set("Etag", fs.etag(obj))
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[objectName]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var data []byte
+		switch {
+		case obj.manifest == nil:
+			data = obj.data
+		case req.URL.Query().Get("multipart-manifest") == "get":
+			var segments []map[string]interface{}
+			for _, segment := range obj.manifest {
+				segments = append(segments, map[string]interface{}{
+					"name":  "/" + segment.Path,
+					"hash":  segment.Etag,
+					"bytes": segment.Size,
+				})
+			}
+			data, _ = json.Marshal(segments)
+		default:
+			data = fs.content(obj)
+		}
+
+		if obj.manifest != nil {
+			w.Header().Set("X-Static-Large-Object", "True")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Etag", fs.etag(obj))
+		w.Header().Set("Last-Modified", obj.lastModified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		if _, ok := objects[objectName]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(objects, objectName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// content returns the data of an object, the segments of a large object are joined
+func (fs *fakeSwift) content(obj *fakeObject) []byte {
+	if obj.manifest == nil {
+		return obj.data
+	}
+
+	var data []byte
+	for _, segment := range obj.manifest {
+		segmentContainer, segmentName, _ := strings.Cut(segment.Path, "/")
+		if segmentObj, ok := fs.containers[segmentContainer][segmentName]; ok {
+			data = append(data, segmentObj.data...)
+		}
+	}
+	return data
+}
+
+func (fs *fakeSwift) size(obj *fakeObject) int {
+	return len(fs.content(obj))
+}
+
+func (fs *fakeSwift) etag(obj *fakeObject) string {
+	sum := md5.Sum(fs.content(obj))
+	return hex.EncodeToString(sum[:])
+}
+
+// objectNames returns the names of the objects in a container
+func (fs *fakeSwift) objectNames(container string) []string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var names []string
+	for name := range fs.containers[container] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newTestClient(t *testing.T, fs *fakeSwift) *blobClient {
+	t.Helper()
+
+	client, err := NewBlobClient([]byte(fmt.Sprintf(`
+auth_url: %s/v3
+region: RegionOne
+user_name: backup
+user_domain_name: Default
+password: secret
+project_name: backup
+project_domain_name: Default
+container: backups
+segment_size: 1024
+timeout: 10s
+`, fs.server.URL)))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	if err := client.conn.ContainerCreate(context.Background(), "backups", nil); err != nil {
+		t.Fatalf("error creating container: %v", err)
+	}
+
+	return client
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("error generating data: %v", err)
+	}
+	return data
+}
+
+func readObject(t *testing.T, client *blobClient, objectName string) []byte {
+	t.Helper()
+
+	reader, err := client.Read(context.Background(), objectName)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", objectName, err)
+	}
+	defer reader.(io.Closer).Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", objectName, err)
+	}
+	return data
+}
+
+func TestCreateSmallObject(t *testing.T) {
+	fs := newFakeSwift(t)
+	client := newTestClient(t, fs)
+
+	data := randomData(t, 100)
+	if err := client.Create(context.Background(), "cluster/small.tar.gz", bytes.NewReader(data)); err != nil {
+		t.Fatalf("error creating: %v", err)
+	}
+
+	if names := fs.objectNames("backups"); !reflect.DeepEqual(names, []string{"cluster/small.tar.gz"}) {
+		t.Errorf("container contains %v", names)
+	}
+	if names := fs.objectNames("backups_segments"); len(names) != 0 {
+		t.Errorf("a small object was uploaded as segments %v", names)
+	}
+
+	if read := readObject(t, client, "cluster/small.tar.gz"); !bytes.Equal(read, data) {
+		t.Errorf("read data does not match the uploaded data")
+	}
+}
+
+func TestStaticLargeObject(t *testing.T) {
+	fs := newFakeSwift(t)
+	client := newTestClient(t, fs)
+	ctx := context.Background()
+
+	data := randomData(t, 3000)
+	if err := client.Create(ctx, "cluster/large.tar.gz", bytes.NewReader(data)); err != nil {
+		t.Fatalf("error creating: %v", err)
+	}
+
+	segments := fs.objectNames("backups_segments")
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, found %v", segments)
+	}
+	for _, segment := range segments {
+		if !strings.HasPrefix(segment, "cluster/large.tar.gz/") {
+			t.Errorf("segment %s is not named after its object", segment)
+		}
+	}
+
+	iterator := client.List(ctx, object.ListOptions{Prefix: "cluster/"})
+	info, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	if info.Key != "cluster/large.tar.gz" || info.Size != int64(len(data)) {
+		t.Errorf("unexpected object info %+v", info)
+	}
+	if _, err := iterator.Next(); err != object.Done {
+		t.Errorf("expected the listing to be done, got %v", err)
+	}
+
+	if read := readObject(t, client, "cluster/large.tar.gz"); !bytes.Equal(read, data) {
+		t.Errorf("read data does not match the uploaded data")
+	}
+
+	if err := client.Delete(ctx, "cluster/large.tar.gz"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if names := fs.objectNames("backups"); len(names) != 0 {
+		t.Errorf("container still contains %v", names)
+	}
+	if names := fs.objectNames("backups_segments"); len(names) != 0 {
+		t.Errorf("segment container still contains %v", names)
+	}
+}
+
+func TestCreateFailedUploadRemovesSegments(t *testing.T) {
+	fs := newFakeSwift(t)
+	client := newTestClient(t, fs)
+
+	fs.failPut = func(container, objectName string) bool {
+		return container == "backups_segments" && strings.HasSuffix(objectName, "/0000000000000003")
+	}
+
+	err := client.Create(context.Background(), "cluster/failed.tar.gz", bytes.NewReader(randomData(t, 4000)))
+	if err == nil {
+		t.Fatalf("expected the upload to fail")
+	}
+
+	if names := fs.objectNames("backups"); len(names) != 0 {
+		t.Errorf("container contains %v after a failed upload", names)
+	}
+	if names := fs.objectNames("backups_segments"); len(names) != 0 {
+		t.Errorf("segments %v were left behind after a failed upload", names)
+	}
+}
+
+func TestCreateMaxManifestSegments(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "exactly the max segments", size: 2048},
+		{name: "more than the max segments", size: 3000, wantErr: true},
+		{name: "far more than the max segments", size: 10000, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := newFakeSwift(t)
+			fs.maxManifestSegments = 2
+			client := newTestClient(t, fs)
+
+			err := client.Create(context.Background(), "cluster/large.tar.gz", bytes.NewReader(randomData(t, test.size)))
+			if !test.wantErr {
+				if err != nil {
+					t.Fatalf("error creating: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "increase segment_size") {
+				t.Fatalf("expected the upload to be refused, got %v", err)
+			}
+			fs.lock.Lock()
+			manifestPuts := fs.manifestPuts
+			fs.lock.Unlock()
+			if manifestPuts != 0 {
+				t.Errorf("the manifest of a refused upload was written")
+			}
+			if names := fs.objectNames("backups_segments"); len(names) != 0 {
+				t.Errorf("segments %v were left behind after a refused upload", names)
+			}
+		})
+	}
+}
+
+func TestListPages(t *testing.T) {
+	fs := newFakeSwift(t)
+	client := newTestClient(t, fs)
+	ctx := context.Background()
+
+	for _, objectName := range []string{"cluster/a", "cluster/b", "cluster/c", "cluster/d", "cluster/e", "other/f"} {
+		if err := client.Create(ctx, objectName, strings.NewReader(objectName)); err != nil {
+			t.Fatalf("error creating %s: %v", objectName, err)
+		}
+	}
+
+	var keys []string
+	iterator := client.List(ctx, object.ListOptions{Prefix: "cluster/", PageSize: 2})
+	for {
+		info, err := iterator.Next()
+		if err == object.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error listing: %v", err)
+		}
+		keys = append(keys, info.Key)
+	}
+
+	expected := []string{"cluster/a", "cluster/b", "cluster/c", "cluster/d", "cluster/e"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("listed %v, expected %v", keys, expected)
+	}
+}
@@ -0,0 +1,404 @@
+package webdav
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+	"github.com/rmb938/kubeadm-backup/pkg/version"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/></D:prop></D:propfind>`
+
+type blobStorageConfig struct {
+	URL string `yaml:"url"`
+
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	PasswordFile    string `yaml:"password_file"`
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+}
+
+type blobClient struct {
+	config     *blobStorageConfig
+	baseURL    *url.URL
+	httpClient *http.Client
+	userAgent  string
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+				ETag          string `xml:"getetag"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func NewBlobClient(rawConfig []byte) (*blobClient, error) {
+	config := &blobStorageConfig{
+		ResponseHeaderTimeout: 2 * time.Minute,
+	}
+	err := yaml.Unmarshal(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webdav blob storage config: %w", err)
+	}
+
+	if config.URL == "" {
+		return nil, fmt.Errorf("missing url in webdav blob storage config")
+	}
+
+	baseURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing webdav url %s: %w", config.URL, err)
+	}
+	// objects are resolved relative to the url so it has to look like a collection
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		baseURL.Path += "/"
+	}
+
+	if config.BearerToken != "" || config.BearerTokenFile != "" {
+		if config.Username != "" {
+			return nil, fmt.Errorf("webdav blob storage config can not set both basic and bearer auth")
+		}
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading webdav ca file %s: %w", config.CAFile, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in webdav ca file %s", config.CAFile)
+		}
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading webdav client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &blobClient{
+		config:  config,
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				MaxIdleConns:          100,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+				ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+				DisableCompression:    true,
+				TLSClientConfig:       tlsConfig,
+			},
+		},
+		userAgent: fmt.Sprintf("kubeadm-backup-%s (%s)", version.Version, runtime.Version()),
+	}, nil
+}
+
+// objectURL resolves an object name against the base url, every path segment is escaped
+func (bc *blobClient) objectURL(objectName string) (string, error) {
+	cleanName := path.Clean(objectName)
+	if cleanName == "." || path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", fmt.Errorf("invalid object name %s", objectName)
+	}
+
+	objectURL := *bc.baseURL
+	objectURL.Path = bc.baseURL.Path + cleanName
+	objectURL.RawPath = ""
+	return objectURL.String(), nil
+}
+
+func (bc *blobClient) newRequest(ctx context.Context, method, requestURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", bc.userAgent)
+
+	switch {
+	case bc.config.Username != "":
+		password := bc.config.Password
+		if bc.config.PasswordFile != "" {
+			password, err = readSecretFile(bc.config.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.SetBasicAuth(bc.config.Username, password)
+	case bc.config.BearerToken != "" || bc.config.BearerTokenFile != "":
+		token := bc.config.BearerToken
+		if bc.config.BearerTokenFile != "" {
+			// the token file is read on every request so rotated tokens are picked up
+			token, err = readSecretFile(bc.config.BearerTokenFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+func (bc *blobClient) do(req *http.Request, expectedStatus ...int) (*http.Response, error) {
+	resp, err := bc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range expectedStatus {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return nil, fmt.Errorf("unexpected response %s to %s %s: %s", resp.Status, req.Method, req.URL.Redacted(), strings.TrimSpace(string(body)))
+}
+
+func (bc *blobClient) Create(ctx context.Context, objectName string, reader io.Reader) error {
+	objectURL, err := bc.objectURL(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := bc.makeCollections(ctx, path.Dir(path.Clean(objectName))); err != nil {
+		return err
+	}
+
+	req, err := bc.newRequest(ctx, http.MethodPut, objectURL, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := bc.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("error writing object %s: %w", objectName, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// makeCollections creates the parent collections of an object, PUT does not create them
+func (bc *blobClient) makeCollections(ctx context.Context, dir string) error {
+	if dir == "." {
+		return nil
+	}
+
+	if err := bc.makeCollections(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	collectionURL, err := bc.objectURL(dir)
+	if err != nil {
+		return err
+	}
+
+	req, err := bc.newRequest(ctx, "MKCOL", collectionURL+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	// 405 is returned when the collection already exists
+	resp, err := bc.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return fmt.Errorf("error creating collection %s: %w", dir, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (bc *blobClient) Read(ctx context.Context, objectName string) (io.Reader, error) {
+	objectURL, err := bc.objectURL(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := bc.newRequest(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bc.do(req, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s: %w", objectName, err)
+	}
+
+	return resp.Body, nil
+}
+
+// List sends a depth 1 PROPFIND per page, many servers refuse depth infinity. Only the collections that can contain
+// objects with the prefix are listed.
+func (bc *blobClient) List(ctx context.Context, opts object.ListOptions) object.Iterator {
+	collections := []string{""}
+
+	return object.NewPageIterator(ctx, func(ctx context.Context) ([]object.Info, bool, error) {
+		collection := collections[len(collections)-1]
+		collections = collections[:len(collections)-1]
+
+		objects, subCollections, err := bc.listCollection(ctx, collection, opts.Prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		collections = append(collections, subCollections...)
+
+		return objects, len(collections) == 0, nil
+	})
+}
+
+// listCollection returns the objects in a collection and the collections in it that can contain objects with the prefix
+func (bc *blobClient) listCollection(ctx context.Context, collection string, prefix string) ([]object.Info, []string, error) {
+	collectionURL := *bc.baseURL
+	collectionURL.Path = bc.baseURL.Path + collection
+	collectionURL.RawPath = ""
+
+	req, err := bc.newRequest(ctx, "PROPFIND", collectionURL.String(), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := bc.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing collection %s: %w", collectionURL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	result := &multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, nil, fmt.Errorf("error parsing listing of collection %s: %w", collectionURL.Redacted(), err)
+	}
+
+	var objects []object.Info
+	var subCollections []string
+	for _, response := range result.Responses {
+		hrefURL, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing href %s: %w", response.Href, err)
+		}
+
+		name, ok := strings.CutPrefix(hrefURL.Path, bc.baseURL.Path)
+		if !ok {
+			continue
+		}
+
+		name = strings.TrimSuffix(name, "/")
+		// the collection itself is part of its listing
+		if name == strings.TrimSuffix(collection, "/") {
+			continue
+		}
+
+		info := object.Info{Key: name}
+		isCollection := false
+		for _, propstat := range response.Propstat {
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				isCollection = true
+			}
+			if size, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+				info.Size = size
+			}
+			if lastModified, err := http.ParseTime(prop.LastModified); err == nil {
+				info.LastModified = lastModified
+			}
+			if prop.ETag != "" {
+				info.ETag = strings.Trim(strings.TrimPrefix(prop.ETag, "W/"), "\"")
+			}
+		}
+
+		if isCollection {
+			if object.DirectoryMayContain(name, prefix) {
+				subCollections = append(subCollections, name+"/")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, info)
+		}
+	}
+
+	return objects, subCollections, nil
+}
+
+func (bc *blobClient) Delete(ctx context.Context, objectName string) error {
+	objectURL, err := bc.objectURL(objectName)
+	if err != nil {
+		return err
+	}
+
+	req, err := bc.newRequest(ctx, http.MethodDelete, objectURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := bc.do(req, http.StatusOK, http.StatusNoContent, http.StatusAccepted)
+	if err != nil {
+		return fmt.Errorf("error deleting object %s: %w", objectName, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (bc *blobClient) Close() error {
+	bc.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func readSecretFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading webdav credentials file %s: %w", file, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,224 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/rmb938/kubeadm-backup/pkg/blob/object"
+)
+
+// statusRecorder remembers the status of every response of the webdav server
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+type testServer struct {
+	lock          sync.Mutex
+	mkcolStatuses []int
+
+	server *httptest.Server
+}
+
+// newTestServer runs an in memory webdav server that is mounted below /dav/ and requires basic auth
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	ts := &testServer{}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if username, password, ok := req.BasicAuth(); !ok || username != "backup" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, req)
+
+		if req.Method == "MKCOL" {
+			ts.lock.Lock()
+			ts.mkcolStatuses = append(ts.mkcolStatuses, recorder.status)
+			ts.lock.Unlock()
+		}
+	}))
+	t.Cleanup(ts.server.Close)
+
+	return ts
+}
+
+func newTestClient(t *testing.T, ts *testServer, password string) *blobClient {
+	t.Helper()
+
+	client, err := NewBlobClient([]byte(fmt.Sprintf("url: %s/dav\nusername: backup\npassword: %s\n", ts.server.URL, password)))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func listKeys(t *testing.T, client *blobClient, prefix string) []string {
+	t.Helper()
+
+	var keys []string
+	iterator := client.List(context.Background(), object.ListOptions{Prefix: prefix})
+	for {
+		info, err := iterator.Next()
+		if err == object.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error listing %q: %v", prefix, err)
+		}
+		keys = append(keys, info.Key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func readObject(t *testing.T, client *blobClient, objectName string) string {
+	t.Helper()
+
+	reader, err := client.Read(context.Background(), objectName)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", objectName, err)
+	}
+	defer reader.(io.Closer).Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", objectName, err)
+	}
+	return string(data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	client := newTestClient(t, ts, "secret")
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"a.tar.gz":                       "a",
+		"cluster/b.tar.gz":               "bb",
+		"cluster/nested/c.tar.gz":        "ccc",
+		"cluster/nested/with space.json": "dddd",
+		"other/e.tar.gz":                 "eeeee",
+	}
+	for objectName, content := range objects {
+		if err := client.Create(ctx, objectName, strings.NewReader(content)); err != nil {
+			t.Fatalf("error creating %s: %v", objectName, err)
+		}
+	}
+
+	// the collections of the later objects already existed
+	ts.lock.Lock()
+	mkcolStatuses := ts.mkcolStatuses
+	ts.lock.Unlock()
+	found := map[int]bool{}
+	for _, status := range mkcolStatuses {
+		found[status] = true
+	}
+	if !found[http.StatusCreated] || !found[http.StatusMethodNotAllowed] {
+		t.Errorf("expected MKCOL to both create and find existing collections, got statuses %v", mkcolStatuses)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{
+			prefix:   "",
+			expected: []string{"a.tar.gz", "cluster/b.tar.gz", "cluster/nested/c.tar.gz", "cluster/nested/with space.json", "other/e.tar.gz"},
+		},
+		{
+			prefix:   "cluster/",
+			expected: []string{"cluster/b.tar.gz", "cluster/nested/c.tar.gz", "cluster/nested/with space.json"},
+		},
+		{
+			prefix:   "cluster/nested/w",
+			expected: []string{"cluster/nested/with space.json"},
+		},
+		{
+			prefix:   "missing/",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run("list "+test.prefix, func(t *testing.T) {
+			if keys := listKeys(t, client, test.prefix); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("listed %v, expected %v", keys, test.expected)
+			}
+		})
+	}
+
+	iterator := client.List(ctx, object.ListOptions{Prefix: "other/"})
+	info, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	if info.Size != int64(len("eeeee")) || info.LastModified.IsZero() || info.ETag == "" {
+		t.Errorf("unexpected object info %+v", info)
+	}
+
+	for objectName, content := range objects {
+		if read := readObject(t, client, objectName); read != content {
+			t.Errorf("read %q from %s, expected %q", read, objectName, content)
+		}
+	}
+
+	if err := client.Create(ctx, "a.tar.gz", strings.NewReader("new")); err != nil {
+		t.Fatalf("error replacing object: %v", err)
+	}
+	if read := readObject(t, client, "a.tar.gz"); read != "new" {
+		t.Errorf("read %q from a replaced object", read)
+	}
+
+	if err := client.Delete(ctx, "cluster/nested/with space.json"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if keys := listKeys(t, client, "cluster/"); !reflect.DeepEqual(keys, []string{"cluster/b.tar.gz", "cluster/nested/c.tar.gz"}) {
+		t.Errorf("listed %v after delete", keys)
+	}
+	if _, err := client.Read(ctx, "cluster/nested/with space.json"); err == nil {
+		t.Errorf("expected reading a deleted object to fail")
+	}
+	if err := client.Delete(ctx, "cluster/nested/with space.json"); err == nil {
+		t.Errorf("expected deleting a missing object to fail")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	ts := newTestServer(t)
+	client := newTestClient(t, ts, "wrong")
+
+	if err := client.Create(context.Background(), "cluster/a.tar.gz", strings.NewReader("a")); err == nil {
+		t.Errorf("expected creating with the wrong password to fail")
+	}
+
+	iterator := client.List(context.Background(), object.ListOptions{})
+	if _, err := iterator.Next(); err == nil || err == object.Done {
+		t.Errorf("expected listing with the wrong password to fail, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package age
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v2"
+)
+
+const suffix = ".age"
+
+type encryptionConfig struct {
+	// Recipients are age X25519 public keys, backups can be decrypted by any of their identities
+	Recipients     []string `yaml:"recipients"`
+	RecipientsFile string   `yaml:"recipients_file"`
+
+	// Passphrase encrypts with scrypt instead of recipients
+	Passphrase     string `yaml:"passphrase"`
+	PassphraseFile string `yaml:"passphrase_file"`
+
+	// IdentityFile holds the age identities used to decrypt backups
+	IdentityFile string `yaml:"identity_file"`
+}
+
+type cipher struct {
+	recipients []age.Recipient
+	identities []age.Identity
+
+	identityFile string
+}
+
+func NewCipher(rawConfig []byte) (*cipher, error) {
+	config := &encryptionConfig{}
+	err := yaml.UnmarshalStrict(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing age encryption config: %w", err)
+	}
+
+	c := &cipher{
+		identityFile: config.IdentityFile,
+	}
+
+	recipients := config.Recipients
+	if config.RecipientsFile != "" {
+		fileRecipients, err := readLines(config.RecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading age recipients file %s: %w", config.RecipientsFile, err)
+		}
+		recipients = append(recipients, fileRecipients...)
+	}
+
+	passphrase := config.Passphrase
+	if config.PassphraseFile != "" {
+		rawPassphrase, err := os.ReadFile(config.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading age passphrase file %s: %w", config.PassphraseFile, err)
+		}
+		passphrase = strings.TrimRight(string(rawPassphrase), "\r\n")
+	}
+
+	if len(recipients) > 0 && passphrase != "" {
+		return nil, fmt.Errorf("age encryption config can not have both recipients and a passphrase")
+	}
+
+	for _, rawRecipient := range recipients {
+		recipient, err := age.ParseX25519Recipient(rawRecipient)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing age recipient %s: %w", rawRecipient, err)
+		}
+		c.recipients = append(c.recipients, recipient)
+	}
+
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error creating age scrypt recipient: %w", err)
+		}
+		c.recipients = append(c.recipients, recipient)
+
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error creating age scrypt identity: %w", err)
+		}
+		c.identities = append(c.identities, identity)
+	}
+
+	if len(c.recipients) == 0 && c.identityFile == "" {
+		return nil, fmt.Errorf("age encryption config needs recipients, a passphrase or an identity file")
+	}
+
+	return c, nil
+}
+
+func (c *cipher) Encrypt(ctx context.Context, writer io.Writer) (io.WriteCloser, error) {
+	if len(c.recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients or passphrase configured to encrypt with")
+	}
+
+	return age.Encrypt(writer, c.recipients...)
+}
+
+func (c *cipher) Decrypt(ctx context.Context, reader io.Reader) (io.Reader, error) {
+	identities := c.identities
+	if c.identityFile != "" {
+		f, err := os.Open(c.identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening age identity file %s: %w", c.identityFile, err)
+		}
+		defer f.Close()
+
+		fileIdentities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing age identity file %s: %w", c.identityFile, err)
+		}
+		identities = append(identities, fileIdentities...)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identity file or passphrase configured to decrypt with")
+	}
+
+	return age.Decrypt(reader, identities...)
+}
+
+func (c *cipher) Suffix() string {
+	return suffix
+}
+
+func readLines(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
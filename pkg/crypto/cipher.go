@@ -0,0 +1,16 @@
+package crypto
+
+import (
+	"context"
+	"io"
+)
+
+// Cipher encrypts backup archives before they are uploaded and decrypts them when they are read back
+type Cipher interface {
+	// Encrypt returns a writer that encrypts into writer, closing it flushes the encrypted stream
+	Encrypt(ctx context.Context, writer io.Writer) (io.WriteCloser, error)
+	// Decrypt returns a reader of the decrypted content of reader
+	Decrypt(ctx context.Context, reader io.Reader) (io.Reader, error)
+	// Suffix is appended to the name of encrypted backups
+	Suffix() string
+}
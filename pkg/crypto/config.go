@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rmb938/kubeadm-backup/pkg/crypto/age"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto/keyfile"
+	"github.com/rmb938/kubeadm-backup/pkg/crypto/vault"
+)
+
+type EncryptionType string
+
+const (
+	AGE     EncryptionType = "AGE"
+	VAULT   EncryptionType = "VAULT"
+	KEYFILE EncryptionType = "KEYFILE"
+)
+
+type EncryptionConfig struct {
+	Type   EncryptionType `yaml:"type"`
+	Config interface{}    `yaml:"config"`
+}
+
+func CreateCipherFromConfig(configFilePath string) (Cipher, error) {
+	encryptionConfig := &EncryptionConfig{}
+
+	rawConfig, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encryption config file %s: %w", configFilePath, err)
+	}
+
+	err = yaml.UnmarshalStrict(rawConfig, encryptionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling encryption config: %w", err)
+	}
+
+	config, err := yaml.Marshal(encryptionConfig.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling content of encryption config: %w", err)
+	}
+
+	var cipher Cipher
+	switch strings.ToUpper(string(encryptionConfig.Type)) {
+	case string(AGE):
+		cipher, err = age.NewCipher(config)
+	case string(VAULT):
+		var keyWrapper KeyWrapper
+		keyWrapper, err = vault.NewKeyWrapper(config)
+		if err == nil {
+			cipher = NewEnvelopeCipher(keyWrapper)
+		}
+	case string(KEYFILE):
+		var keyWrapper KeyWrapper
+		keyWrapper, err = keyfile.NewKeyWrapper(config)
+		if err == nil {
+			cipher = NewEnvelopeCipher(keyWrapper)
+		}
+	default:
+		return nil, fmt.Errorf("encryption config with type %s not supported", encryptionConfig.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher %s: %w", encryptionConfig.Type, err)
+	}
+
+	return cipher, nil
+}
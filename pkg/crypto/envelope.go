@@ -0,0 +1,295 @@
+package crypto
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The envelope format is the magic, a big endian uint32 length of the json header, the json header
+// and then the archive split into AES-256-GCM sealed chunks.
+//
+// Each chunk nonce is the nonce prefix from the header, a big endian uint32 chunk counter and a byte
+// that is 1 for the final chunk. The header is the additional data of every chunk so it can not be
+// modified and chunks can not be reordered, dropped or truncated without failing to decrypt.
+const (
+	envelopeMagic   = "KBENVLP1"
+	envelopeSuffix  = ".enc"
+	dataKeySize     = 32
+	chunkSize       = 64 * 1024
+	noncePrefixSize = 7
+	maxHeaderSize   = 64 * 1024
+)
+
+type envelopeHeader struct {
+	KeyWrapper  string `json:"key_wrapper"`
+	WrappedKey  []byte `json:"wrapped_key"`
+	NoncePrefix []byte `json:"nonce_prefix"`
+	ChunkSize   int    `json:"chunk_size"`
+}
+
+type envelopeCipher struct {
+	keyWrapper KeyWrapper
+}
+
+// NewEnvelopeCipher creates a cipher that encrypts every backup with a random data key wrapped by keyWrapper
+func NewEnvelopeCipher(keyWrapper KeyWrapper) Cipher {
+	return &envelopeCipher{
+		keyWrapper: keyWrapper,
+	}
+}
+
+func (c *envelopeCipher) Encrypt(ctx context.Context, writer io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %w", err)
+	}
+
+	wrappedKey, err := c.keyWrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key with %s: %w", c.keyWrapper.Name(), err)
+	}
+
+	header := envelopeHeader{
+		KeyWrapper:  c.keyWrapper.Name(),
+		WrappedKey:  wrappedKey,
+		NoncePrefix: make([]byte, noncePrefixSize),
+		ChunkSize:   chunkSize,
+	}
+	if _, err := rand.Read(header.NoncePrefix); err != nil {
+		return nil, fmt.Errorf("error generating nonce prefix: %w", err)
+	}
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling envelope header: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prelude := make([]byte, 0, len(envelopeMagic)+4+len(rawHeader))
+	prelude = append(prelude, envelopeMagic...)
+	prelude = binary.BigEndian.AppendUint32(prelude, uint32(len(rawHeader)))
+	prelude = append(prelude, rawHeader...)
+	if _, err := writer.Write(prelude); err != nil {
+		return nil, fmt.Errorf("error writing envelope header: %w", err)
+	}
+
+	return &envelopeWriter{
+		writer:      writer,
+		aead:        aead,
+		noncePrefix: header.NoncePrefix,
+		aad:         rawHeader,
+		buf:         make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (c *envelopeCipher) Decrypt(ctx context.Context, reader io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(envelopeMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil {
+		return nil, fmt.Errorf("error reading envelope magic: %w", err)
+	}
+	if string(magic) != envelopeMagic {
+		return nil, fmt.Errorf("backup is not envelope encrypted")
+	}
+
+	rawHeaderSize := make([]byte, 4)
+	if _, err := io.ReadFull(reader, rawHeaderSize); err != nil {
+		return nil, fmt.Errorf("error reading envelope header size: %w", err)
+	}
+	headerSize := binary.BigEndian.Uint32(rawHeaderSize)
+	if headerSize > maxHeaderSize {
+		return nil, fmt.Errorf("envelope header of %d bytes is too large", headerSize)
+	}
+
+	rawHeader := make([]byte, headerSize)
+	if _, err := io.ReadFull(reader, rawHeader); err != nil {
+		return nil, fmt.Errorf("error reading envelope header: %w", err)
+	}
+
+	header := envelopeHeader{}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("error parsing envelope header: %w", err)
+	}
+
+	if header.KeyWrapper != c.keyWrapper.Name() {
+		return nil, fmt.Errorf("backup data key was wrapped with %s but %s is configured", header.KeyWrapper, c.keyWrapper.Name())
+	}
+	if len(header.NoncePrefix) != noncePrefixSize {
+		return nil, fmt.Errorf("invalid envelope header")
+	}
+	// the header is only authenticated with the first chunk, so the chunk buffer is never sized from it
+	if header.ChunkSize != chunkSize {
+		return nil, fmt.Errorf("unsupported envelope chunk size %d, expected %d", header.ChunkSize, chunkSize)
+	}
+
+	dataKey, err := c.keyWrapper.UnwrapKey(ctx, header.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key with %s: %w", c.keyWrapper.Name(), err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelopeReader{
+		reader:      bufio.NewReader(reader),
+		aead:        aead,
+		noncePrefix: header.NoncePrefix,
+		aad:         rawHeader,
+		chunk:       make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (c *envelopeCipher) Suffix() string {
+	return envelopeSuffix
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes but is %d", dataKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aes cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(noncePrefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, noncePrefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type envelopeWriter struct {
+	writer      io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	aad         []byte
+
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+func (w *envelopeWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed envelope writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// only seal full chunks once more data arrives, the final chunk is sealed by Close
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *envelopeWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *envelopeWriter) seal(last bool) error {
+	if w.counter == ^uint32(0) {
+		return errors.New("envelope chunk counter overflow")
+	}
+
+	sealed := w.aead.Seal(nil, chunkNonce(w.noncePrefix, w.counter, last), w.buf, w.aad)
+	if _, err := w.writer.Write(sealed); err != nil {
+		return err
+	}
+
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+type envelopeReader struct {
+	reader      *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	aad         []byte
+
+	chunk     []byte
+	plaintext []byte
+	counter   uint32
+	done      bool
+}
+
+func (r *envelopeReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *envelopeReader) open() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	if err == io.EOF {
+		return fmt.Errorf("envelope encrypted backup is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// a short chunk or nothing after a full chunk means this is the final chunk
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, peekErr := r.reader.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+
+	plaintext, err := r.aead.Open(r.chunk[:0], chunkNonce(r.noncePrefix, r.counter, last), r.chunk[:n], r.aad)
+	if err != nil {
+		return fmt.Errorf("error decrypting envelope chunk %d: %w", r.counter, err)
+	}
+
+	r.counter++
+	r.plaintext = plaintext
+	r.done = last
+	return nil
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// plainKeyWrapper does not wrap keys at all, it only exists to test the envelope format
+type plainKeyWrapper struct{}
+
+func (plainKeyWrapper) Name() string { return "plain" }
+
+func (plainKeyWrapper) WrapKey(_ context.Context, key []byte) ([]byte, error) { return key, nil }
+
+func (plainKeyWrapper) UnwrapKey(_ context.Context, wrappedKey []byte) ([]byte, error) {
+	return wrappedKey, nil
+}
+
+func encrypt(t *testing.T, cipher Cipher, plaintext []byte) []byte {
+	t.Helper()
+
+	encrypted := &bytes.Buffer{}
+	writer, err := cipher.Encrypt(context.Background(), encrypted)
+	if err != nil {
+		t.Fatalf("error creating encrypt writer: %v", err)
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("error closing encrypt writer: %v", err)
+	}
+
+	return encrypted.Bytes()
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "partial chunk", size: 100},
+		{name: "exactly one chunk", size: chunkSize},
+		{name: "multiple chunks", size: 3*chunkSize + 17},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cipher := NewEnvelopeCipher(plainKeyWrapper{})
+
+			plaintext := make([]byte, test.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := cipher.Decrypt(context.Background(), bytes.NewReader(encrypt(t, cipher, plaintext)))
+			if err != nil {
+				t.Fatalf("error creating decrypt reader: %v", err)
+			}
+			decrypted, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("error decrypting: %v", err)
+			}
+
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Fatalf("decrypted %d bytes do not match the %d encrypted bytes", len(decrypted), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestEnvelopeTruncated(t *testing.T) {
+	cipher := NewEnvelopeCipher(plainKeyWrapper{})
+	encrypted := encrypt(t, cipher, make([]byte, 2*chunkSize+10))
+
+	reader, err := cipher.Decrypt(context.Background(), bytes.NewReader(encrypted[:len(encrypted)-30]))
+	if err != nil {
+		t.Fatalf("error creating decrypt reader: %v", err)
+	}
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Fatal("expected decrypting a truncated backup to fail")
+	}
+}
+
+func TestEnvelopeRejectsChunkSize(t *testing.T) {
+	cipher := NewEnvelopeCipher(plainKeyWrapper{})
+	encrypted := encrypt(t, cipher, []byte("snapshot"))
+
+	// rewrite the unauthenticated header with a huge chunk size before the first chunk is checked
+	headerStart := len(envelopeMagic) + 4
+	headerSize := int(binary.BigEndian.Uint32(encrypted[len(envelopeMagic):headerStart]))
+	header := envelopeHeader{}
+	if err := json.Unmarshal(encrypted[headerStart:headerStart+headerSize], &header); err != nil {
+		t.Fatal(err)
+	}
+	header.ChunkSize = 1 << 40
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crafted := append([]byte(envelopeMagic), binary.BigEndian.AppendUint32(nil, uint32(len(rawHeader)))...)
+	crafted = append(crafted, rawHeader...)
+	crafted = append(crafted, encrypted[headerStart+headerSize:]...)
+
+	_, err = cipher.Decrypt(context.Background(), bytes.NewReader(crafted))
+	if err == nil || !strings.Contains(err.Error(), "chunk size") {
+		t.Fatalf("expected an unsupported chunk size error, got %v", err)
+	}
+}
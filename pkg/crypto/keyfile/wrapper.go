@@ -0,0 +1,106 @@
+package keyfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+const keySize = 32
+
+type keyWrapperConfig struct {
+	// KeyFile contains a 32 byte key, either raw, hex or base64 encoded
+	KeyFile string `yaml:"key_file"`
+}
+
+// keyWrapper wraps data keys with AES-256-GCM using a static key read from a file
+type keyWrapper struct {
+	aead cipher.AEAD
+}
+
+func NewKeyWrapper(rawConfig []byte) (*keyWrapper, error) {
+	config := &keyWrapperConfig{}
+	err := yaml.UnmarshalStrict(rawConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key file config: %w", err)
+	}
+
+	if config.KeyFile == "" {
+		return nil, fmt.Errorf("missing key_file in key file config")
+	}
+
+	rawKey, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file %s: %w", config.KeyFile, err)
+	}
+
+	key, err := decodeKey(rawKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key file %s: %w", config.KeyFile, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aes cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcm cipher: %w", err)
+	}
+
+	return &keyWrapper{
+		aead: aead,
+	}, nil
+}
+
+func (kw *keyWrapper) Name() string {
+	return "keyfile"
+}
+
+func (kw *keyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	nonce := make([]byte, kw.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return kw.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func (kw *keyWrapper) UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) < kw.aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+
+	nonce, ciphertext := wrappedKey[:kw.aead.NonceSize()], wrappedKey[kw.aead.NonceSize():]
+	key, err := kw.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting wrapped key, the key file may not match: %w", err)
+	}
+
+	return key, nil
+}
+
+func decodeKey(rawKey []byte) ([]byte, error) {
+	if len(rawKey) == keySize {
+		return rawKey, nil
+	}
+
+	trimmed := bytes.TrimSpace(rawKey)
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && len(key) == keySize {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key must be %d bytes, raw or hex or base64 encoded", keySize)
+}
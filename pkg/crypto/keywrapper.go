@@ -0,0 +1,13 @@
+package crypto
+
+import (
+	"context"
+)
+
+// KeyWrapper encrypts and decrypts the data keys used for envelope encryption, usually with a key held by a KMS
+type KeyWrapper interface {
+	// Name identifies the key wrapper in the header of encrypted backups
+	Name() string
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
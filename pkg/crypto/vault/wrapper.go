@@ -0,0 +1,253 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+var defaultConfig = keyWrapperConfig{
+	Mount: "transit",
+	KubernetesAuth: kubernetesAuthConfig{
+		Mount:     "kubernetes",
+		TokenFile: defaultKubernetesTokenFile,
+	},
+}
+
+type kubernetesAuthConfig struct {
+	Role      string `yaml:"role"`
+	Mount     string `yaml:"mount"`
+	TokenFile string `yaml:"token_file"`
+}
+
+type keyWrapperConfig struct {
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+
+	// Mount is where the transit secrets engine is mounted
+	Mount   string `yaml:"mount"`
+	KeyName string `yaml:"key_name"`
+
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+
+	KubernetesAuth kubernetesAuthConfig `yaml:"kubernetes_auth"`
+
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// keyWrapper wraps data keys with the HashiCorp Vault transit secrets engine
+type keyWrapper struct {
+	config     *keyWrapperConfig
+	httpClient *http.Client
+
+	tokenLock sync.Mutex
+	token     string
+}
+
+func NewKeyWrapper(rawConfig []byte) (*keyWrapper, error) {
+	config := defaultConfig
+	err := yaml.UnmarshalStrict(rawConfig, &config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing vault config: %w", err)
+	}
+
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("missing vault address in vault config")
+	}
+	if config.KeyName == "" {
+		return nil, fmt.Errorf("missing transit key_name in vault config")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vault ca file %s: %w", config.CAFile, err)
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("failed to add vault ca certificate from %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	kw := &keyWrapper{
+		config: &config,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+		token: config.Token,
+	}
+
+	if kw.token == "" && config.TokenFile != "" {
+		rawToken, err := os.ReadFile(config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vault token file %s: %w", config.TokenFile, err)
+		}
+		kw.token = strings.TrimSpace(string(rawToken))
+	}
+
+	if kw.token == "" && config.KubernetesAuth.Role == "" {
+		kw.token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if kw.token == "" && config.KubernetesAuth.Role == "" {
+		return nil, fmt.Errorf("vault config needs a token, token file or kubernetes auth role")
+	}
+
+	return kw, nil
+}
+
+func (kw *keyWrapper) Name() string {
+	return "vault-transit"
+}
+
+func (kw *keyWrapper) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	request := map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	}
+	response := struct {
+		Ciphertext string `json:"ciphertext"`
+	}{}
+
+	if err := kw.transit(ctx, "encrypt", request, &response); err != nil {
+		return nil, err
+	}
+
+	return []byte(response.Ciphertext), nil
+}
+
+func (kw *keyWrapper) UnwrapKey(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	request := map[string]string{
+		"ciphertext": string(wrappedKey),
+	}
+	response := struct {
+		Plaintext string `json:"plaintext"`
+	}{}
+
+	if err := kw.transit(ctx, "decrypt", request, &response); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(response.Plaintext)
+}
+
+func (kw *keyWrapper) transit(ctx context.Context, operation string, request interface{}, response interface{}) error {
+	token, err := kw.getToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	apiPath := fmt.Sprintf("%s/%s/%s", strings.Trim(kw.config.Mount, "/"), operation, kw.config.KeyName)
+	return kw.do(ctx, apiPath, token, request, response)
+}
+
+func (kw *keyWrapper) getToken(ctx context.Context) (string, error) {
+	kw.tokenLock.Lock()
+	defer kw.tokenLock.Unlock()
+
+	if kw.token != "" {
+		return kw.token, nil
+	}
+
+	// log in with the kubernetes service account token
+	jwt, err := os.ReadFile(kw.config.KubernetesAuth.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading kubernetes service account token %s: %w", kw.config.KubernetesAuth.TokenFile, err)
+	}
+
+	request := map[string]string{
+		"role": kw.config.KubernetesAuth.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	response := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+
+	apiPath := fmt.Sprintf("auth/%s/login", strings.Trim(kw.config.KubernetesAuth.Mount, "/"))
+	if err := kw.do(ctx, apiPath, "", request, &response); err != nil {
+		return "", fmt.Errorf("error logging into vault with kubernetes auth: %w", err)
+	}
+
+	// login tokens expire so only keep them for a single backup
+	return response.Auth.ClientToken, nil
+}
+
+func (kw *keyWrapper) do(ctx context.Context, apiPath string, token string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshaling vault request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimRight(kw.config.Address, "/"), apiPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating vault request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if kw.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", kw.config.Namespace)
+	}
+
+	resp, err := kw.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling vault %s: %w", apiPath, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return fmt.Errorf("error reading vault response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		vaultErrors := struct {
+			Errors []string `json:"errors"`
+		}{}
+		_ = json.Unmarshal(respBody, &vaultErrors)
+		return fmt.Errorf("vault %s returned %s: %s", apiPath, resp.Status, strings.Join(vaultErrors.Errors, ", "))
+	}
+
+	// auth responses are at the top level, secret engine responses are wrapped in data
+	if strings.HasPrefix(apiPath, "auth/") {
+		return json.Unmarshal(respBody, response)
+	}
+
+	wrapped := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(respBody, &wrapped); err != nil {
+		return fmt.Errorf("error parsing vault response: %w", err)
+	}
+
+	return json.Unmarshal(wrapped.Data, response)
+}
@@ -0,0 +1,204 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeTransit is a Vault server with the transit secrets engine and kubernetes auth, ciphertexts are the base64
+// plaintext behind a vault:v1: prefix
+type fakeTransit struct {
+	t *testing.T
+
+	token     string
+	namespace string
+	role      string
+	jwt       string
+
+	lock     sync.Mutex
+	requests []string
+}
+
+func (f *fakeTransit) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	f.requests = append(f.requests, req.URL.Path)
+	f.lock.Unlock()
+
+	if req.Method != http.MethodPost {
+		f.fail(rw, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if req.Header.Get("X-Vault-Namespace") != f.namespace {
+		f.fail(rw, http.StatusForbidden, "wrong namespace")
+		return
+	}
+
+	body := map[string]string{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		f.fail(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.URL.Path == "/v1/auth/kubernetes/login" {
+		if body["role"] != f.role || body["jwt"] != f.jwt {
+			f.fail(rw, http.StatusForbidden, "permission denied")
+			return
+		}
+		f.respond(rw, map[string]interface{}{"auth": map[string]string{"client_token": f.token}})
+		return
+	}
+
+	if req.Header.Get("X-Vault-Token") != f.token {
+		f.fail(rw, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch req.URL.Path {
+	case "/v1/transit/encrypt/backups":
+		f.respond(rw, map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+	case "/v1/transit/decrypt/backups":
+		plaintext, ok := strings.CutPrefix(body["ciphertext"], "vault:v1:")
+		if !ok {
+			f.fail(rw, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		f.respond(rw, map[string]interface{}{"data": map[string]string{"plaintext": plaintext}})
+	default:
+		f.fail(rw, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func (f *fakeTransit) respond(rw http.ResponseWriter, response interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(response); err != nil {
+		f.t.Errorf("error writing response: %v", err)
+	}
+}
+
+func (f *fakeTransit) fail(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string][]string{"errors": {message}})
+}
+
+func TestKeyWrapper(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		config    string
+		namespace string
+		wrapErr   string
+		loginPath bool
+	}{
+		{
+			name:   "token",
+			config: "key_name: backups\ntoken: s.root\n",
+		},
+		{
+			name:      "kubernetes auth",
+			config:    fmt.Sprintf("key_name: backups\nkubernetes_auth:\n  role: kubeadm-backup\n  token_file: %s\n", tokenFile),
+			loginPath: true,
+		},
+		{
+			name:      "namespace",
+			config:    "key_name: backups\ntoken: s.root\nnamespace: team-a\n",
+			namespace: "team-a",
+		},
+		{
+			name:    "wrong token",
+			config:  "key_name: backups\ntoken: s.wrong\n",
+			wrapErr: "permission denied",
+		},
+		{
+			name:    "unknown key",
+			config:  "key_name: other\ntoken: s.root\n",
+			wrapErr: "404",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeTransit{t: t, token: "s.root", namespace: test.namespace, role: "kubeadm-backup", jwt: "service-account-jwt"}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			kw, err := NewKeyWrapper([]byte(test.config + "address: " + server.URL + "\n"))
+			if err != nil {
+				t.Fatalf("error creating key wrapper: %v", err)
+			}
+
+			key := bytes.Repeat([]byte{7}, 32)
+			wrapped, err := kw.WrapKey(context.Background(), key)
+			if test.wrapErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wrapErr) {
+					t.Fatalf("expected error containing %q, got %v", test.wrapErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error wrapping key: %v", err)
+			}
+			if want := "vault:v1:" + base64.StdEncoding.EncodeToString(key); string(wrapped) != want {
+				t.Fatalf("wrapped key is %q, expected %q", wrapped, want)
+			}
+
+			unwrapped, err := kw.UnwrapKey(context.Background(), wrapped)
+			if err != nil {
+				t.Fatalf("error unwrapping key: %v", err)
+			}
+			if !bytes.Equal(key, unwrapped) {
+				t.Fatalf("unwrapped key does not match")
+			}
+
+			logins := 0
+			for _, path := range fake.requests {
+				if path == "/v1/auth/kubernetes/login" {
+					logins++
+				}
+			}
+			if test.loginPath && logins != 2 {
+				t.Fatalf("expected a kubernetes login per operation, got %d", logins)
+			}
+			if !test.loginPath && logins != 0 {
+				t.Fatalf("expected no kubernetes login, got %d", logins)
+			}
+		})
+	}
+}
+
+func TestNewKeyWrapperValidation(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "missing address", config: "key_name: backups\ntoken: s.root\n"},
+		{name: "missing key name", config: "address: http://vault:8200\ntoken: s.root\n"},
+		{name: "missing token", config: "address: http://vault:8200\nkey_name: backups\n"},
+		{name: "unknown field", config: "address: http://vault:8200\nkey_name: backups\ntoken: s.root\nkey: x\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewKeyWrapper([]byte(test.config)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
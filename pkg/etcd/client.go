@@ -13,6 +13,17 @@ import (
 
 type Client struct {
 	clientv3Client *clientv3.Client
+
+	endpoint string
+}
+
+// Status is the status of the etcd member at the configured endpoint
+type Status struct {
+	Endpoint  string
+	MemberID  uint64
+	ClusterID uint64
+	Revision  int64
+	Version   string
 }
 
 func NewEtcdClient(endpoint, caFile, keyFile, certFile string) (*Client, error) {
@@ -31,6 +42,7 @@ func NewEtcdClient(endpoint, caFile, keyFile, certFile string) (*Client, error)
 
 	client := &Client{
 		clientv3Client: clientv3Client,
+		endpoint:       endpoint,
 	}
 	return client, nil
 }
@@ -69,6 +81,35 @@ func (c *Client) Snapshot(ctx context.Context) (io.ReadCloser, error) {
 	return c.clientv3Client.Snapshot(ctx)
 }
 
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp, err := c.clientv3Client.Status(ctx, c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Status{
+		Endpoint:  c.endpoint,
+		MemberID:  resp.Header.MemberId,
+		ClusterID: resp.Header.ClusterId,
+		Revision:  resp.Header.Revision,
+		Version:   resp.Version,
+	}, nil
+}
+
+// Get returns the value of a key, nil is returned when the key does not exist
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.clientv3Client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return resp.Kvs[0].Value, nil
+}
+
 func (c *Client) Sync(ctx context.Context) error {
 	return c.clientv3Client.Sync(ctx)
 }
@@ -0,0 +1,59 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// Election is a campaign for leadership under a key, leadership is held until Done is closed
+type Election struct {
+	session  *concurrency.Session
+	election *concurrency.Election
+}
+
+// NewElection creates a lease that expires ttl after this process stopped refreshing it and an election on key using it
+func (c *Client) NewElection(key string, ttl time.Duration) (*Election, error) {
+	session, err := concurrency.NewSession(c.clientv3Client, concurrency.WithTTL(int(ttl.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("error creating etcd session: %w", err)
+	}
+
+	return &Election{
+		session:  session,
+		election: concurrency.NewElection(session, key),
+	}, nil
+}
+
+// Campaign blocks until this process is the leader, the election lost its lease or ctx is done
+func (e *Election) Campaign(ctx context.Context, identity string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-e.session.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return e.election.Campaign(ctx, identity)
+}
+
+// Done is closed when the lease of the election expired, leadership is lost then
+func (e *Election) Done() <-chan struct{} {
+	return e.session.Done()
+}
+
+// Resign gives up leadership so another process can take over without waiting for the lease to expire
+func (e *Election) Resign(ctx context.Context) error {
+	return e.election.Resign(ctx)
+}
+
+// Close revokes the lease, giving up leadership or the campaign
+func (e *Election) Close() error {
+	return e.session.Close()
+}
@@ -0,0 +1,120 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+)
+
+// retryInterval is how long to wait before campaigning again after talking to etcd failed
+const retryInterval = 5 * time.Second
+
+var (
+	// IsLeader is a prometheus metric which is a Gauge of whether this instance is the leader
+	IsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_leader",
+		Help: "Whether this instance is the leader that takes backups.",
+	},
+	)
+	// LeaderTransitions is a prometheus metric which is a Counter of how often this instance became the leader
+	LeaderTransitions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kubeadm_backup_leader_transitions_total",
+		Help: "How often this instance became the leader.",
+	},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(IsLeader, LeaderTransitions)
+}
+
+type Config struct {
+	// Key is the etcd key prefix all instances campaign on
+	Key string
+	// Identity is stored as the value of the leader key, it names the leader
+	Identity string
+	// TTL is how long leadership is kept after the leader stopped refreshing its lease
+	TTL time.Duration
+}
+
+type elector struct {
+	etcdClient *etcd.Client
+	config     Config
+
+	log logr.Logger
+}
+
+func NewElector(etcdClient *etcd.Client, config Config, log logr.Logger) *elector {
+	return &elector{
+		etcdClient: etcdClient,
+		config:     config,
+
+		log: log,
+	}
+}
+
+// Run campaigns for leadership forever, lead is called every time this instance becomes the leader and its context
+// is cancelled when leadership is lost. The next campaign starts once lead returned.
+func (e *elector) Run(lead func(ctx context.Context)) {
+	e.setLeader(false)
+
+	for {
+		if err := e.term(lead); err != nil {
+			e.log.Error(err, "leader election failed, retrying", "retry-interval", retryInterval)
+			time.Sleep(retryInterval)
+		}
+	}
+}
+
+// term campaigns once and leads until the lease of the election expires
+func (e *elector) term(lead func(ctx context.Context)) error {
+	election, err := e.etcdClient.NewElection(e.config.Key, e.config.TTL)
+	if err != nil {
+		return err
+	}
+	defer election.Close()
+
+	e.log.Info("campaigning for leadership", "key", e.config.Key, "identity", e.config.Identity)
+	if err := election.Campaign(context.Background(), e.config.Identity); err != nil {
+		return err
+	}
+
+	e.log.Info("became leader")
+	e.setLeader(true)
+	LeaderTransitions.Inc()
+
+	// anything lead is doing is aborted once the lease expired, another instance may already be leading
+	leadCTX, leadCancel := context.WithCancel(context.Background())
+	defer leadCancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCTX)
+	}()
+
+	select {
+	case <-election.Done():
+		e.log.Info("lost leadership, aborting the running backup")
+		e.setLeader(false)
+		leadCancel()
+		<-done
+	case <-done:
+		e.setLeader(false)
+	}
+
+	return nil
+}
+
+func (e *elector) setLeader(leader bool) {
+	if leader {
+		IsLeader.Set(1)
+	} else {
+		IsLeader.Set(0)
+	}
+	metrics.SetHealth("leader", leader)
+}
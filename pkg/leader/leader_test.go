@@ -0,0 +1,167 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"github.com/rmb938/kubeadm-backup/pkg/etcd"
+)
+
+const testKey = "/kubeadm-backup/leader"
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error finding a free port: %v", err)
+	}
+	defer listener.Close()
+
+	return url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+// startTestEtcd runs a single member etcd for the test and returns its client url
+func startTestEtcd(t *testing.T) string {
+	t.Helper()
+
+	peerURL, clientURL := freeURL(t), freeURL(t)
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+	cfg.LogLevel = "error"
+	cfg.LogOutputs = []string{"stderr"}
+
+	etcdServer, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("error starting etcd: %v", err)
+	}
+	t.Cleanup(etcdServer.Close)
+
+	select {
+	case <-etcdServer.Server.ReadyNotify():
+	case <-time.After(time.Minute):
+		t.Fatalf("etcd was not ready after a minute")
+	}
+
+	return clientURL.String()
+}
+
+func newTestElector(t *testing.T, endpoint, identity string) *elector {
+	t.Helper()
+
+	etcdClient, err := etcd.NewEtcdClient(endpoint, "", "", "")
+	if err != nil {
+		t.Fatalf("error creating etcd client: %v", err)
+	}
+	t.Cleanup(func() { etcdClient.Close() })
+
+	// the minimum ttl etcd grants with its default election timeout
+	return NewElector(etcdClient, Config{Key: testKey, Identity: identity, TTL: 2 * time.Second}, logr.Discard())
+}
+
+func receive(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("timed out waiting until %s", what)
+	}
+}
+
+func TestTerm(t *testing.T) {
+	endpoint := startTestEtcd(t)
+
+	first := newTestElector(t, endpoint, "first")
+	second := newTestElector(t, endpoint, "second")
+
+	firstLeads := make(chan struct{})
+	firstCancelled := make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		firstDone <- first.term(func(ctx context.Context) {
+			close(firstLeads)
+			<-ctx.Done()
+			close(firstCancelled)
+		})
+	}()
+
+	receive(t, firstLeads, "the first instance leads")
+	if testutil.ToFloat64(IsLeader) != 1 {
+		t.Errorf("leader metric is not set while leading")
+	}
+
+	secondLeads := make(chan struct{})
+	secondDone := make(chan error, 1)
+	go func() {
+		secondDone <- second.term(func(ctx context.Context) {
+			close(secondLeads)
+		})
+	}()
+
+	// the second instance waits while the lease of the first one is refreshed, which happens every ttl/3
+	select {
+	case <-secondLeads:
+		t.Fatalf("the second instance leads while the first one still does")
+	case <-time.After(3 * time.Second):
+	}
+
+	// revoking the lease is what happens when the first instance stops refreshing it for the ttl
+	rawClient, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}})
+	if err != nil {
+		t.Fatalf("error creating etcd client: %v", err)
+	}
+	defer rawClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := rawClient.Get(ctx, testKey+"/", clientv3.WithFirstCreate()...)
+	if err != nil || len(resp.Kvs) == 0 {
+		t.Fatalf("error finding the leader key: %v", err)
+	}
+	if string(resp.Kvs[0].Value) != "first" {
+		t.Fatalf("leader key belongs to %s", resp.Kvs[0].Value)
+	}
+	if _, err := rawClient.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+
+	receive(t, firstCancelled, "the lead context of the first instance is cancelled")
+	select {
+	case err := <-firstDone:
+		if err != nil {
+			t.Errorf("error from the term of the first instance: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("the term of the first instance did not end")
+	}
+
+	receive(t, secondLeads, "the second instance leads")
+	select {
+	case err := <-secondDone:
+		if err != nil {
+			t.Errorf("error from the term of the second instance: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("the term of the second instance did not end after lead returned")
+	}
+
+	if testutil.ToFloat64(IsLeader) != 0 {
+		t.Errorf("leader metric is still set after leading ended")
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GatedCollector only collects the metrics of its collectors while it is open, so metrics that are only meaningful
+// while the instance does some work are not exported with their zero values
+type GatedCollector struct {
+	collectors []prometheus.Collector
+	open       atomic.Bool
+}
+
+func NewGatedCollector(open bool, collectors ...prometheus.Collector) *GatedCollector {
+	gc := &GatedCollector{
+		collectors: collectors,
+	}
+	gc.open.Store(open)
+	return gc
+}
+
+// SetOpen sets whether the metrics of the collectors are collected
+func (gc *GatedCollector) SetOpen(open bool) {
+	gc.open.Store(open)
+}
+
+func (gc *GatedCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, collector := range gc.collectors {
+		collector.Describe(descs)
+	}
+}
+
+func (gc *GatedCollector) Collect(metrics chan<- prometheus.Metric) {
+	if !gc.open.Load() {
+		return
+	}
+	for _, collector := range gc.collectors {
+		collector.Collect(metrics)
+	}
+}
@@ -1,9 +1,11 @@
 package metrics
 
 import (
+"encoding/json"
 "fmt"
 "net"
 "net/http"
+"sync"
 
 "github.com/go-logr/logr"
 "github.com/prometheus/client_golang/prometheus"
@@ -12,8 +14,18 @@ import (
 
 var (
 	Log logr.Logger
+
+	healthLock sync.RWMutex
+	health     = map[string]interface{}{}
 )
 
+// SetHealth sets a field of the JSON document served by the health endpoint
+func SetHealth(name string, value interface{}) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	health[name] = value
+}
+
 // RegistererGatherer combines both parts of the API of a Prometheus
 // registry, both the Registerer and the Gatherer interfaces.
 type RegistererGatherer interface {
@@ -34,7 +46,11 @@ func ServeMetrics() {
 	mux := http.NewServeMux()
 	mux.Handle(metricsPath, handler)
 	mux.Handle("/", http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		healthLock.RLock()
+		defer healthLock.RUnlock()
+		rw.Header().Set("Content-Type", "application/json")
 		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(health)
 	}))
 	server := http.Server{
 		Handler: mux,
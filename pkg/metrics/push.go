@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Push sends the given collectors to a prometheus pushgateway.
+//
+// Metrics are added to the group instead of replacing it so values that are not
+// pushed, like the last successful backup time after a failed backup, are kept.
+func Push(ctx context.Context, url, job string, grouping map[string]string, collectors ...prometheus.Collector) error {
+	pusher := push.New(url, job)
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	for _, collector := range collectors {
+		pusher = pusher.Collector(collector)
+	}
+
+	if err := pusher.AddContext(ctx); err != nil {
+		return fmt.Errorf("error pushing metrics to pushgateway %s: %w", url, err)
+	}
+
+	return nil
+}
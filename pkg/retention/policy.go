@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Policy decides which backups are kept, a backup is kept when any rule keeps it and deleted otherwise
+type Policy struct {
+	// KeepLast keeps the newest backups
+	KeepLast int `yaml:"keep_last"`
+	// KeepDaily, KeepWeekly, KeepMonthly and KeepYearly keep the newest backup of that many of the most recent days,
+	// weeks, months and years that have a backup. Periods are in UTC, weeks are ISO 8601 weeks.
+	KeepDaily   int `yaml:"keep_daily"`
+	KeepWeekly  int `yaml:"keep_weekly"`
+	KeepMonthly int `yaml:"keep_monthly"`
+	KeepYearly  int `yaml:"keep_yearly"`
+	// KeepWithin keeps every backup taken less than it ago, this is how the backup-ttl flag keeps backups
+	KeepWithin time.Duration `yaml:"keep_within"`
+}
+
+// Backup is what the policy needs to know about a backup
+type Backup struct {
+	Time time.Time
+	// Pinned backups are always kept, PinReason is logged as why
+	Pinned    bool
+	PinReason string
+}
+
+// Decision is whether a single backup is kept
+type Decision struct {
+	Keep bool
+	// Reasons are the rules keeping the backup, or why it is deleted
+	Reasons []string
+}
+
+type periodRule struct {
+	name string
+	keep int
+	key  func(t time.Time) string
+}
+
+func (p Policy) periodRules() []periodRule {
+	return []periodRule{
+		{name: "keep_daily", keep: p.KeepDaily, key: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{name: "keep_weekly", keep: p.KeepWeekly, key: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "keep_monthly", keep: p.KeepMonthly, key: func(t time.Time) string { return t.Format("2006-01") }},
+		{name: "keep_yearly", keep: p.KeepYearly, key: func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+func (p Policy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.KeepYearly < 0 || p.KeepWithin < 0 {
+		return errors.New("retention policy values can not be negative")
+	}
+
+	if p == (Policy{}) {
+		return errors.New("retention policy does not keep any backups")
+	}
+
+	return nil
+}
+
+func (p Policy) String() string {
+	var rules []string
+	if p.KeepLast > 0 {
+		rules = append(rules, fmt.Sprintf("keep_last=%d", p.KeepLast))
+	}
+	for _, rule := range p.periodRules() {
+		if rule.keep > 0 {
+			rules = append(rules, fmt.Sprintf("%s=%d", rule.name, rule.keep))
+		}
+	}
+	if p.KeepWithin > 0 {
+		rules = append(rules, fmt.Sprintf("keep_within=%s", p.KeepWithin))
+	}
+	return strings.Join(rules, " ")
+}
+
+// Apply decides which of the backups are kept, backups must be sorted from oldest to newest and the decisions are
+// returned in the same order. Pinned backups are kept and still count towards the other rules.
+func (p Policy) Apply(backups []Backup, now time.Time) []Decision {
+	decisions := make([]Decision, len(backups))
+
+	rules := p.periodRules()
+	lastKeys := make([]string, len(rules))
+	kept := make([]int, len(rules))
+	keptLast := 0
+
+	// walking from the newest backup makes the first backup seen in a period the newest one in it
+	for i := len(backups) - 1; i >= 0; i-- {
+		decision := &decisions[i]
+		backupTime := backups[i].Time.UTC()
+
+		if backups[i].Pinned {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("pinned: %s", backups[i].PinReason))
+		}
+
+		if keptLast < p.KeepLast {
+			keptLast++
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("keep_last %d of %d", keptLast, p.KeepLast))
+		}
+
+		for r, rule := range rules {
+			key := rule.key(backupTime)
+			if key == lastKeys[r] {
+				continue
+			}
+			lastKeys[r] = key
+
+			if kept[r] < rule.keep {
+				kept[r]++
+				decision.Reasons = append(decision.Reasons, fmt.Sprintf("%s %s %d of %d", rule.name, key, kept[r], rule.keep))
+			}
+		}
+
+		if p.KeepWithin > 0 && now.Sub(backups[i].Time) <= p.KeepWithin {
+			decision.Reasons = append(decision.Reasons, fmt.Sprintf("keep_within %s", p.KeepWithin))
+		}
+
+		decision.Keep = len(decision.Reasons) > 0
+		if !decision.Keep {
+			decision.Reasons = []string{"not kept by any rule"}
+		}
+	}
+
+	return decisions
+}
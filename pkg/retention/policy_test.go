@@ -0,0 +1,325 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("error parsing time %s: %v", value, err)
+	}
+	return parsed
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		now     string
+		backups []string
+		// pinned are the indexes of the pinned backups
+		pinned []int
+		// kept are the indexes of the backups that are expected to be kept
+		kept []int
+		// reasons are the expected reasons of some of the backups
+		reasons map[int][]string
+	}{
+		{
+			name:    "no backups",
+			policy:  Policy{KeepLast: 3},
+			now:     "2024-03-10T12:00:00Z",
+			backups: nil,
+			kept:    nil,
+		},
+		{
+			name:   "keep_last",
+			policy: Policy{KeepLast: 2},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-10T08:00:00Z",
+				"2024-03-10T09:00:00Z",
+				"2024-03-10T10:00:00Z",
+				"2024-03-10T11:00:00Z",
+			},
+			kept: []int{2, 3},
+			reasons: map[int][]string{
+				0: {"not kept by any rule"},
+				2: {"keep_last 2 of 2"},
+				3: {"keep_last 1 of 2"},
+			},
+		},
+		{
+			name:   "keep_last more than there are backups",
+			policy: Policy{KeepLast: 5},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-10T08:00:00Z",
+				"2024-03-10T09:00:00Z",
+			},
+			kept: []int{0, 1},
+		},
+		{
+			name:   "keep_daily keeps the newest backup of each day",
+			policy: Policy{KeepDaily: 2},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-07T10:00:00Z",
+				"2024-03-08T01:00:00Z",
+				"2024-03-08T23:00:00Z",
+				"2024-03-10T01:00:00Z",
+				"2024-03-10T11:00:00Z",
+			},
+			kept: []int{2, 4},
+			reasons: map[int][]string{
+				2: {"keep_daily 2024-03-08 2 of 2"},
+				4: {"keep_daily 2024-03-10 1 of 2"},
+			},
+		},
+		{
+			name:   "keep_daily periods are utc days",
+			policy: Policy{KeepDaily: 2},
+			now:    "2024-03-11T12:00:00Z",
+			backups: []string{
+				// 2024-03-10 in utc
+				"2024-03-10T18:00:00-05:00",
+				// both 2024-03-11 in utc although they are on different local days
+				"2024-03-10T23:30:00-05:00",
+				"2024-03-11T01:00:00-05:00",
+			},
+			kept: []int{0, 2},
+			reasons: map[int][]string{
+				0: {"keep_daily 2024-03-10 2 of 2"},
+				2: {"keep_daily 2024-03-11 1 of 2"},
+			},
+		},
+		{
+			name:   "keep_daily at midnight utc",
+			policy: Policy{KeepDaily: 1},
+			now:    "2024-03-11T12:00:00Z",
+			backups: []string{
+				"2024-03-10T23:59:59Z",
+				"2024-03-11T00:00:00Z",
+			},
+			kept: []int{1},
+		},
+		{
+			name:   "keep_weekly uses iso weeks across the year boundary",
+			policy: Policy{KeepWeekly: 2},
+			now:    "2025-01-01T12:00:00Z",
+			backups: []string{
+				// 2024-W51
+				"2024-12-22T10:00:00Z",
+				// 2024-W52
+				"2024-12-28T10:00:00Z",
+				"2024-12-29T10:00:00Z",
+				// 2025-W01 starts on monday 2024-12-30
+				"2024-12-30T10:00:00Z",
+				"2024-12-31T10:00:00Z",
+			},
+			kept: []int{2, 4},
+			reasons: map[int][]string{
+				2: {"keep_weekly 2024-W52 2 of 2"},
+				4: {"keep_weekly 2025-W01 1 of 2"},
+			},
+		},
+		{
+			name:   "keep_monthly",
+			policy: Policy{KeepMonthly: 2},
+			now:    "2024-04-15T12:00:00Z",
+			backups: []string{
+				"2024-01-31T23:00:00Z",
+				"2024-02-01T00:00:00Z",
+				"2024-02-29T23:00:00Z",
+				"2024-04-01T00:00:00Z",
+				"2024-04-15T10:00:00Z",
+			},
+			kept: []int{2, 4},
+			reasons: map[int][]string{
+				2: {"keep_monthly 2024-02 2 of 2"},
+				4: {"keep_monthly 2024-04 1 of 2"},
+			},
+		},
+		{
+			name:   "keep_yearly",
+			policy: Policy{KeepYearly: 2},
+			now:    "2024-06-01T12:00:00Z",
+			backups: []string{
+				"2021-06-01T00:00:00Z",
+				"2022-12-31T23:59:59Z",
+				"2023-01-01T00:00:00Z",
+				"2023-12-31T23:00:00Z",
+				"2024-05-01T00:00:00Z",
+			},
+			kept: []int{3, 4},
+		},
+		{
+			name:   "keep_within is inclusive",
+			policy: Policy{KeepWithin: 24 * time.Hour},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-09T11:59:59Z",
+				"2024-03-09T12:00:00Z",
+				"2024-03-10T11:00:00Z",
+			},
+			kept: []int{1, 2},
+			reasons: map[int][]string{
+				1: {"keep_within 24h0m0s"},
+			},
+		},
+		{
+			name:   "rules combine",
+			policy: Policy{KeepLast: 1, KeepDaily: 2, KeepWithin: 2 * time.Hour},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-08T10:00:00Z",
+				"2024-03-09T10:00:00Z",
+				"2024-03-10T09:00:00Z",
+				"2024-03-10T10:00:00Z",
+				"2024-03-10T11:00:00Z",
+			},
+			kept: []int{1, 3, 4},
+			reasons: map[int][]string{
+				3: {"keep_within 2h0m0s"},
+				4: {"keep_last 1 of 1", "keep_daily 2024-03-10 1 of 2", "keep_within 2h0m0s"},
+			},
+		},
+		{
+			name:   "pinned backups are kept",
+			policy: Policy{KeepLast: 1},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-01T10:00:00Z",
+				"2024-03-05T10:00:00Z",
+				"2024-03-10T10:00:00Z",
+			},
+			pinned: []int{0},
+			kept:   []int{0, 2},
+			reasons: map[int][]string{
+				0: {"pinned: test"},
+			},
+		},
+		{
+			name:   "pinned backups still fill keep_last",
+			policy: Policy{KeepLast: 2},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-08T10:00:00Z",
+				"2024-03-09T10:00:00Z",
+				"2024-03-10T10:00:00Z",
+			},
+			pinned: []int{2},
+			kept:   []int{1, 2},
+			reasons: map[int][]string{
+				2: {"pinned: test", "keep_last 1 of 2"},
+			},
+		},
+		{
+			name:   "pinned backups still fill a period bucket",
+			policy: Policy{KeepDaily: 1},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-09T10:00:00Z",
+				"2024-03-10T08:00:00Z",
+				"2024-03-10T10:00:00Z",
+			},
+			pinned: []int{2},
+			kept:   []int{2},
+			reasons: map[int][]string{
+				0: {"not kept by any rule"},
+				2: {"pinned: test", "keep_daily 2024-03-10 1 of 1"},
+			},
+		},
+		{
+			name:   "an older pinned backup does not take a period bucket",
+			policy: Policy{KeepDaily: 2},
+			now:    "2024-03-10T12:00:00Z",
+			backups: []string{
+				"2024-03-09T10:00:00Z",
+				"2024-03-10T08:00:00Z",
+				"2024-03-10T10:00:00Z",
+			},
+			pinned: []int{1},
+			kept:   []int{0, 1, 2},
+			reasons: map[int][]string{
+				0: {"keep_daily 2024-03-09 2 of 2"},
+				1: {"pinned: test"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backups := make([]Backup, len(test.backups))
+			for i, backupTime := range test.backups {
+				backups[i].Time = mustParse(t, backupTime)
+			}
+			for _, i := range test.pinned {
+				backups[i].Pinned = true
+				backups[i].PinReason = "test"
+			}
+
+			decisions := test.policy.Apply(backups, mustParse(t, test.now))
+			if len(decisions) != len(backups) {
+				t.Fatalf("got %d decisions for %d backups", len(decisions), len(backups))
+			}
+
+			var kept []int
+			for i, decision := range decisions {
+				if decision.Keep {
+					kept = append(kept, i)
+				}
+				if len(decision.Reasons) == 0 {
+					t.Errorf("backup %d has no reasons", i)
+				}
+			}
+			if !reflect.DeepEqual(kept, test.kept) {
+				t.Errorf("kept backups %v, expected %v", kept, test.kept)
+				for i, decision := range decisions {
+					t.Logf("backup %d %s: %v", i, test.backups[i], decision.Reasons)
+				}
+			}
+
+			for i, reasons := range test.reasons {
+				if !reflect.DeepEqual(decisions[i].Reasons, reasons) {
+					t.Errorf("backup %d has reasons %q, expected %q", i, decisions[i].Reasons, reasons)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "keep_last", policy: Policy{KeepLast: 1}},
+		{name: "keep_within", policy: Policy{KeepWithin: time.Hour}},
+		{name: "empty", policy: Policy{}, wantErr: true},
+		{name: "negative", policy: Policy{KeepLast: 1, KeepDaily: -1}, wantErr: true},
+		{name: "negative keep_within", policy: Policy{KeepLast: 1, KeepWithin: -time.Hour}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, expected an error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	policy := Policy{KeepLast: 3, KeepDaily: 7, KeepYearly: 1, KeepWithin: 36 * time.Hour}
+	expected := "keep_last=3 keep_daily=7 keep_yearly=1 keep_within=36h0m0s"
+	if policy.String() != expected {
+		t.Errorf("got %q, expected %q", policy.String(), expected)
+	}
+}
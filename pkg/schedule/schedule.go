@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	// the container image has no zoneinfo, embedding it makes every timezone available
+	_ "time/tzdata"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+
+	"github.com/rmb938/kubeadm-backup/pkg/metrics"
+)
+
+var (
+	// NextRunTime is a prometheus metric which is a Gauge of when the next backup is scheduled
+	NextRunTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubeadm_backup_next_scheduled_backup_time",
+		Help: "When the next backup is scheduled to run, including jitter and blackout windows. Expressed as a Unix Epoch Time.",
+	},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(NextRunTime)
+}
+
+type Config struct {
+	// Interval runs immediately, or when resumed an interval after the last run, and then every interval. It is only
+	// used when Cron is empty.
+	Interval time.Duration
+	// Cron is a standard five field cron expression or a descriptor like @daily, runs are aligned to the wall clock
+	Cron string
+	// Location is the timezone of Cron and the blackout windows
+	Location *time.Location
+
+	// Jitter delays every run by a random duration up to it
+	Jitter time.Duration
+	// Blackouts are the times of day in Location runs are postponed out of
+	Blackouts []Window
+}
+
+type Scheduler struct {
+	config Config
+	cron   cron.Schedule
+
+	// base is the last run time before jitter and blackout windows were applied
+	base time.Time
+	// first is when the first run of an interval schedule is due, it is now unless the schedule was resumed
+	first time.Time
+	rand  *rand.Rand
+
+	log logr.Logger
+}
+
+func NewScheduler(config Config, log logr.Logger) (*Scheduler, error) {
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+
+	if config.Jitter < 0 {
+		return nil, fmt.Errorf("jitter can not be negative")
+	}
+
+	if err := validateWindows(config.Blackouts); err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{
+		config: config,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		log: log,
+	}
+
+	if config.Cron == "" {
+		if config.Interval <= 0 {
+			return nil, fmt.Errorf("interval must be greater than 0 when no cron expression is given")
+		}
+		return s, nil
+	}
+
+	spec := config.Cron
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		spec = fmt.Sprintf("CRON_TZ=%s %s", config.Location, spec)
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cron expression %q: %w", config.Cron, err)
+	}
+	s.cron = schedule
+
+	return s, nil
+}
+
+// Next returns when to run after now, the first run of an interval schedule is now
+func (s *Scheduler) Next(now time.Time) time.Time {
+	switch {
+	case s.cron != nil:
+		s.base = s.cron.Next(now)
+	case s.base.IsZero():
+		s.base = now
+		if s.first.After(now) {
+			s.base = s.first
+		}
+	default:
+		s.base = s.base.Add(s.config.Interval)
+		// runs that were missed while a run took longer than the interval are skipped like a ticker does
+		if s.base.Before(now) {
+			s.base = now.Add(s.config.Interval - now.Sub(s.base)%s.config.Interval)
+		}
+	}
+
+	next := s.base
+	if s.config.Jitter > 0 {
+		next = next.Add(time.Duration(s.rand.Int63n(int64(s.config.Jitter))))
+	}
+
+	return s.outsideBlackouts(next)
+}
+
+// outsideBlackouts postpones t to the end of the blackout windows it falls into
+func (s *Scheduler) outsideBlackouts(t time.Time) time.Time {
+	// windows never cover the whole day, so every postponement ends in a gap or another window
+	for postponed := true; postponed; {
+		postponed = false
+		for _, window := range s.config.Blackouts {
+			if end, ok := window.end(t.In(s.config.Location)); ok {
+				t = end
+				postponed = true
+			}
+		}
+	}
+	return t
+}
+
+// Resume restarts the schedule from the last run, an interval schedule waits until an interval after it instead of
+// running immediately. Cron schedules are aligned to the wall clock and ignore it.
+func (s *Scheduler) Resume(last time.Time) {
+	s.base = time.Time{}
+	s.first = time.Time{}
+	if s.cron != nil || last.IsZero() {
+		return
+	}
+	s.first = last.Add(s.config.Interval)
+}
+
+// Run calls run at every scheduled time until stop is closed, a run that is still going when the next one is due
+// delays it
+func (s *Scheduler) Run(stop <-chan struct{}, run func()) {
+	for {
+		next := s.Next(time.Now())
+		NextRunTime.Set(float64(next.Unix()))
+		s.log.Info("next run scheduled", "time", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			NextRunTime.Set(0)
+			return
+		case <-timer.C:
+		}
+
+		run()
+	}
+}
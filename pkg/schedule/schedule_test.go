@@ -0,0 +1,312 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("error loading location %s: %v", name, err)
+	}
+	return location
+}
+
+func mustWindows(t *testing.T, raw string) []Window {
+	t.Helper()
+
+	windows, err := ParseWindows(raw)
+	if err != nil {
+		t.Fatalf("error parsing windows %s: %v", raw, err)
+	}
+	return windows
+}
+
+// step is a single call to Next, now is when it is called and expected is the run it should return
+type step struct {
+	now      time.Time
+	expected time.Time
+}
+
+func runSteps(t *testing.T, s *Scheduler, steps []step) {
+	t.Helper()
+
+	for i, step := range steps {
+		if next := s.Next(step.now); !next.Equal(step.expected) {
+			t.Errorf("step %d: next run after %s is %s, expected %s", i, step.now.Format(time.RFC3339), next.Format(time.RFC3339), step.expected.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestNextBlackouts(t *testing.T) {
+	utc := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 5, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		blackouts string
+		steps     []step
+	}{
+		{
+			name:      "interval run postponed to the end of the window",
+			config:    Config{Interval: time.Hour},
+			blackouts: "02:00-03:00",
+			steps: []step{
+				{now: utc(1, 30), expected: utc(1, 30)},
+				{now: utc(1, 31), expected: utc(3, 0)},
+				// the schedule continues from the unpostponed run
+				{now: utc(3, 1), expected: utc(3, 30)},
+			},
+		},
+		{
+			name:      "window start is inclusive and end exclusive",
+			config:    Config{Interval: time.Hour},
+			blackouts: "02:00-03:00",
+			steps: []step{
+				{now: utc(2, 0), expected: utc(3, 0)},
+				{now: utc(3, 0), expected: utc(3, 0)},
+			},
+		},
+		{
+			name:      "window wrapping midnight",
+			config:    Config{Interval: time.Hour},
+			blackouts: "23:30-00:30",
+			steps: []step{
+				{now: utc(23, 45), expected: utc(24, 30)},
+				{now: utc(24, 31), expected: utc(24, 45)},
+			},
+		},
+		{
+			name:      "overlapping windows postpone to the end of the last one",
+			config:    Config{Interval: time.Hour},
+			blackouts: "02:00-03:00,02:45-04:00",
+			steps: []step{
+				{now: utc(2, 15), expected: utc(4, 0)},
+			},
+		},
+		{
+			name:      "cron run postponed to the end of the window",
+			config:    Config{Cron: "0 2 * * *"},
+			blackouts: "01:30-02:30",
+			steps: []step{
+				{now: utc(0, 0), expected: utc(2, 30)},
+				{now: utc(2, 31), expected: utc(26, 30)},
+			},
+		},
+		{
+			name:      "cron run outside of the window",
+			config:    Config{Cron: "0 4 * * *"},
+			blackouts: "01:30-02:30",
+			steps: []step{
+				{now: utc(0, 0), expected: utc(4, 0)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Location = time.UTC
+			config.Blackouts = mustWindows(t, test.blackouts)
+
+			s, err := NewScheduler(config, logr.Discard())
+			if err != nil {
+				t.Fatalf("error creating scheduler: %v", err)
+			}
+			runSteps(t, s, test.steps)
+		})
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	newYork := mustLocation(t, "America/New_York")
+	local := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name      string
+		config    Config
+		blackouts string
+		steps     []step
+	}{
+		{
+			name:   "cron stays on the wall clock when clocks spring forward",
+			config: Config{Cron: "0 1 * * *"},
+			steps: []step{
+				{now: local(time.March, 9, 0, 0), expected: local(time.March, 9, 1, 0)},
+				{now: local(time.March, 9, 1, 0), expected: local(time.March, 10, 1, 0)},
+				// only 23 hours later
+				{now: local(time.March, 10, 1, 0), expected: local(time.March, 11, 1, 0)},
+			},
+		},
+		{
+			name:   "cron stays on the wall clock when clocks fall back",
+			config: Config{Cron: "0 3 * * *"},
+			steps: []step{
+				{now: local(time.November, 2, 3, 0), expected: local(time.November, 3, 3, 0)},
+				// 25 hours later
+				{now: local(time.November, 3, 3, 0), expected: local(time.November, 4, 3, 0)},
+			},
+		},
+		{
+			name:   "interval is elapsed time, not wall clock time",
+			config: Config{Interval: 24 * time.Hour},
+			steps: []step{
+				{now: local(time.March, 9, 12, 0), expected: local(time.March, 9, 12, 0)},
+				{now: local(time.March, 9, 12, 1), expected: local(time.March, 10, 13, 0)},
+			},
+		},
+		{
+			name:      "blackout covers both repeated hours when clocks fall back",
+			config:    Config{Interval: 30 * time.Minute},
+			blackouts: "01:00-02:00",
+			steps: []step{
+				{now: local(time.November, 3, 0, 45), expected: local(time.November, 3, 0, 45)},
+				// 01:15 EDT, the window ends at 02:00 EST two hours later
+				{now: local(time.November, 3, 0, 46), expected: local(time.November, 3, 2, 0)},
+			},
+		},
+		{
+			name:      "blackout in the local timezone",
+			config:    Config{Interval: time.Hour},
+			blackouts: "02:00-04:00",
+			steps: []step{
+				// 07:00 UTC is 03:00 EDT on the day clocks spring forward
+				{now: time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC), expected: local(time.March, 10, 4, 0)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Location = newYork
+			config.Blackouts = mustWindows(t, test.blackouts)
+
+			s, err := NewScheduler(config, logr.Discard())
+			if err != nil {
+				t.Fatalf("error creating scheduler: %v", err)
+			}
+			runSteps(t, s, test.steps)
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config Config
+		last   time.Time
+		steps  []step
+	}{
+		{
+			name:   "no previous run runs immediately",
+			config: Config{Interval: time.Hour},
+			steps: []step{
+				{now: now, expected: now},
+				{now: now.Add(time.Minute), expected: now.Add(time.Hour)},
+			},
+		},
+		{
+			name:   "recent run waits an interval after it",
+			config: Config{Interval: time.Hour},
+			last:   now.Add(-20 * time.Minute),
+			steps: []step{
+				{now: now, expected: now.Add(40 * time.Minute)},
+				{now: now.Add(41 * time.Minute), expected: now.Add(100 * time.Minute)},
+			},
+		},
+		{
+			name:   "overdue run runs immediately",
+			config: Config{Interval: time.Hour},
+			last:   now.Add(-3 * time.Hour),
+			steps: []step{
+				{now: now, expected: now},
+				{now: now.Add(time.Minute), expected: now.Add(time.Hour)},
+			},
+		},
+		{
+			name:   "missed runs are skipped",
+			config: Config{Interval: time.Hour},
+			last:   now.Add(-20 * time.Minute),
+			steps: []step{
+				{now: now, expected: now.Add(40 * time.Minute)},
+				// the run took over two intervals
+				{now: now.Add(3*time.Hour + 10*time.Minute), expected: now.Add(3*time.Hour + 40*time.Minute)},
+			},
+		},
+		{
+			name:   "cron ignores the previous run",
+			config: Config{Cron: "0 * * * *"},
+			last:   now.Add(-5 * time.Minute),
+			steps: []step{
+				{now: now.Add(time.Minute), expected: now.Add(time.Hour)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Location = time.UTC
+
+			s, err := NewScheduler(config, logr.Discard())
+			if err != nil {
+				t.Fatalf("error creating scheduler: %v", err)
+			}
+
+			// a schedule that already ran is reset by resuming it
+			s.Next(now.Add(-24 * time.Hour))
+			s.Resume(test.last)
+
+			runSteps(t, s, test.steps)
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewScheduler(Config{Interval: time.Hour, Jitter: 10 * time.Minute, Location: time.UTC}, logr.Discard())
+	if err != nil {
+		t.Fatalf("error creating scheduler: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		base := now.Add(time.Duration(i) * time.Hour)
+		next := s.Next(base)
+		if next.Before(base) || !next.Before(base.Add(10*time.Minute)) {
+			t.Fatalf("run %d at %s is not within the jitter after %s", i, next, base)
+		}
+	}
+}
+
+func TestNewSchedulerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "no interval or cron", config: Config{}},
+		{name: "negative jitter", config: Config{Interval: time.Hour, Jitter: -time.Minute}},
+		{name: "invalid cron", config: Config{Cron: "not a cron"}},
+		{name: "blackouts cover the whole day", config: Config{Interval: time.Hour, Blackouts: []Window{{Start: 0, End: 720}, {Start: 720, End: 0}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewScheduler(test.config, logr.Discard()); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
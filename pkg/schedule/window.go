@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Window is a time of day range, it wraps past midnight when Start is after End
+type Window struct {
+	// Start and End are minutes after midnight, Start is inclusive and End exclusive
+	Start int
+	End   int
+}
+
+// ParseWindows parses a comma separated list of HH:MM-HH:MM windows like 02:00-03:00,23:30-00:30
+func ParseWindows(raw string) ([]Window, error) {
+	var windows []Window
+	if raw == "" {
+		return windows, nil
+	}
+
+	for _, rawWindow := range strings.Split(raw, ",") {
+		rawStart, rawEnd, ok := strings.Cut(strings.TrimSpace(rawWindow), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", rawWindow)
+		}
+
+		start, err := parseTimeOfDay(rawStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", rawWindow, err)
+		}
+		end, err := parseTimeOfDay(rawEnd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", rawWindow, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid window %q, start and end are the same", rawWindow)
+		}
+
+		windows = append(windows, Window{Start: start, End: end})
+	}
+
+	return windows, nil
+}
+
+func parseTimeOfDay(raw string) (int, error) {
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// end returns when the window t is in ends, false is returned when t is outside the window
+func (w Window) end(t time.Time) (time.Time, bool) {
+	minute := t.Hour()*60 + t.Minute()
+
+	var inside bool
+	endDay := 0
+	if w.Start < w.End {
+		inside = minute >= w.Start && minute < w.End
+	} else {
+		inside = minute >= w.Start || minute < w.End
+		if minute >= w.Start {
+			endDay = 1
+		}
+	}
+	if !inside {
+		return time.Time{}, false
+	}
+
+	year, month, day := t.Date()
+	return time.Date(year, month, day+endDay, w.End/60, w.End%60, 0, 0, t.Location()), true
+}
+
+// validateWindows makes sure the windows leave some time of the day to run in
+func validateWindows(windows []Window) error {
+	var covered [minutesPerDay]bool
+	for _, w := range windows {
+		if w.Start < 0 || w.Start >= minutesPerDay || w.End < 0 || w.End >= minutesPerDay || w.Start == w.End {
+			return fmt.Errorf("invalid blackout window %s", w)
+		}
+		for minute := w.Start; minute != w.End; minute = (minute + 1) % minutesPerDay {
+			covered[minute] = true
+		}
+	}
+
+	for _, c := range covered {
+		if !c {
+			return nil
+		}
+	}
+	return fmt.Errorf("blackout windows cover the whole day")
+}
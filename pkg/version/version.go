@@ -0,0 +1,4 @@
+package version
+
+// Version is the version of kubeadm-backup, it is set at build time with -ldflags "-X"
+var Version = "0.0.1"